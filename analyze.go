@@ -0,0 +1,284 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/tabwriter"
+)
+
+// Statistics for typing a text with a keyboard and layout
+// Bigrams are pairs of consecutive characters typed by fingers other than
+// the thumbs, as those are what layouts are judged by.
+type LayoutAnalysis struct {
+	Chars       int            // Characters in the text that could be typed
+	Unknown     int            // Characters no key types
+	Presses     int            // Key presses, including Shift
+	HomeRow     int            // Presses on the home row
+	FingerLoad  map[Finger]int // Presses by finger
+	Travel      float64        // How far the fingers moved, in key units
+	Bigrams     int
+	SameFinger  int // Bigrams typed with one finger on two different keys
+	Alternating int // Bigrams typed with one hand, then the other
+	RowJumps    int // Bigrams on one hand reaching over the home row, e.g. from top to bottom
+}
+
+// Where the fingers rest, by key code
+var homeKeys = map[Finger]string{
+	LeftPinky:   "KC_A",
+	LeftRing:    "KC_S",
+	LeftMiddle:  "KC_D",
+	LeftIndex:   "KC_F",
+	LeftThumb:   "KC_SPC",
+	RightThumb:  "KC_SPC",
+	RightIndex:  "KC_J",
+	RightMiddle: "KC_K",
+	RightRing:   "KC_L",
+	RightPinky:  "KC_SCLN",
+}
+
+// Center of a key in key units
+func keyCenter(key Key) (x, y float64) {
+	x, y, w, h := key.Rect()
+	return x + w/2, y + h/2
+}
+
+// Run a text through a keyboard, with its fingers and layout as they are
+// Fingers start on their home keys and stay on the last key they pressed,
+// so travel is measured from there. Runs of whitespace are typed as a
+// single space.
+func analyzeLayout(kb Keyboard, text string) LayoutAnalysis {
+	analysis := LayoutAnalysis{FingerLoad: make(map[Finger]int)}
+
+	type point struct{ x, y float64 }
+	fingers := make(map[Finger]point)
+	for finger, code := range homeKeys {
+		if i := kb.KeyByCode(code); i >= 0 {
+			x, y := keyCenter(kb.Keys[i])
+			fingers[finger] = point{x, y}
+		}
+	}
+	homeRow := layoutRowCodes[2]
+
+	prev := -1
+	for _, r := range strings.Join(strings.Fields(text), " ") {
+		keys := kb.KeysFor(r)
+		if len(keys) == 0 {
+			analysis.Unknown++
+			prev = -1
+			continue
+		}
+		analysis.Chars++
+
+		for _, i := range keys {
+			key := kb.Keys[i]
+			analysis.Presses++
+			analysis.FingerLoad[key.Finger]++
+			if slices.Contains(homeRow, key.Identity.Code) {
+				analysis.HomeRow++
+			}
+
+			x, y := keyCenter(key)
+			if from, ok := fingers[key.Finger]; ok {
+				analysis.Travel += math.Hypot(x-from.x, y-from.y)
+			}
+			fingers[key.Finger] = point{x, y}
+		}
+
+		// Shift doesn't count towards bigrams, only the key it's used with
+		if prev >= 0 {
+			analysis.addBigram(kb.Keys[prev], kb.Keys[keys[0]])
+		}
+		prev = keys[0]
+	}
+
+	return analysis
+}
+
+// Count a pair of consecutive key presses
+func (a *LayoutAnalysis) addBigram(from, to Key) {
+	isThumb := func(f Finger) bool { return f == LeftThumb || f == RightThumb || f == NoFinger }
+	if isThumb(from.Finger) || isThumb(to.Finger) {
+		return
+	}
+
+	a.Bigrams++
+	switch {
+	case from.Finger.Hand() != to.Finger.Hand():
+		a.Alternating++
+	case from.Finger == to.Finger && from.Identity.Code != to.Identity.Code:
+		a.SameFinger++
+		fallthrough
+	default:
+		_, fromY := keyCenter(from)
+		_, toY := keyCenter(to)
+		if math.Abs(fromY-toY) >= 1.5 {
+			a.RowJumps++
+		}
+	}
+}
+
+// Part of a whole in percent, 0 if there's no whole
+func percent(part, whole int) float64 {
+	if whole == 0 {
+		return 0
+	}
+	return 100 * float64(part) / float64(whole)
+}
+
+// A keyboard with a layout applied, leaving the original alone
+// An empty layout ID goes by the printed legends.
+func withLayout(kb Keyboard, id string) Keyboard {
+	kb.Keys = slices.Clone(kb.Keys)
+	applyLayout(&kb, layouts[id])
+	return kb
+}
+
+// Name of a layout for display, by ID
+func layoutName(id string) string {
+	if layout, ok := layouts[id]; ok {
+		return layout.Name
+	}
+	return "Printed"
+}
+
+// Analyze a text with each of the layouts (by ID), and format the results
+// as a table with a column per layout
+func formatAnalysis(kb Keyboard, ids []string, text string) string {
+	results := make([]LayoutAnalysis, len(ids))
+	for i, id := range ids {
+		results[i] = analyzeLayout(withLayout(kb, id), text)
+	}
+
+	var b strings.Builder
+	w := tabwriter.NewWriter(&b, 0, 0, 2, ' ', tabwriter.AlignRight)
+	row := func(label string, value func(i int, a LayoutAnalysis) string) {
+		// Padded, so the labels line up on the left while values don't
+		fmt.Fprintf(w, "%-20s\t", label)
+		for i, a := range results {
+			fmt.Fprint(w, value(i, a)+"\t")
+		}
+		fmt.Fprintln(w)
+	}
+	rate := func(label string, part, whole func(a LayoutAnalysis) int) {
+		row(label, func(_ int, a LayoutAnalysis) string {
+			return fmt.Sprintf("%.1f%%", percent(part(a), whole(a)))
+		})
+	}
+
+	row("", func(i int, _ LayoutAnalysis) string { return layoutName(ids[i]) })
+	bigrams := func(a LayoutAnalysis) int { return a.Bigrams }
+	rate("Same finger bigrams", func(a LayoutAnalysis) int { return a.SameFinger }, bigrams)
+	rate("Hand alternation", func(a LayoutAnalysis) int { return a.Alternating }, bigrams)
+	rate("Row jumps", func(a LayoutAnalysis) int { return a.RowJumps }, bigrams)
+	row("Travel per character", func(_ int, a LayoutAnalysis) string {
+		return fmt.Sprintf("%.2fu", a.Travel/float64(max(a.Chars, 1)))
+	})
+	presses := func(a LayoutAnalysis) int { return a.Presses }
+	rate("Home row", func(a LayoutAnalysis) int { return a.HomeRow }, presses)
+	row("Finger load", func(int, LayoutAnalysis) string { return "" })
+	for _, finger := range []Finger{LeftPinky, LeftRing, LeftMiddle, LeftIndex, RightIndex, RightMiddle, RightRing, RightPinky} {
+		label := strings.ReplaceAll(finger.String(), "-", " ")
+		rate("  "+label, func(a LayoutAnalysis) int { return a.FingerLoad[finger] }, presses)
+	}
+	rate("  thumbs", func(a LayoutAnalysis) int { return a.FingerLoad[LeftThumb] + a.FingerLoad[RightThumb] }, presses)
+	if unknown := results[0].Unknown; unknown > 0 {
+		row("Not on the keyboard", func(_ int, a LayoutAnalysis) string { return fmt.Sprint(a.Unknown) })
+	}
+
+	w.Flush()
+	return b.String()
+}
+
+// The built-in corpus for layout analysis: all the quotes
+func analysisCorpus() string {
+	return strings.Join(parseQuotes(builtinQuotes), " ")
+}
+
+// IDs of the layouts to compare by default: the printed legends, then
+// every layout
+func analysisLayouts() []string {
+	return append([]string{""}, layoutNames()...)
+}
+
+// Analyze layouts from the command line, writing a table to stdout
+//
+//	typr2 analyze [-keyboard file] [-format kle|qmk|via] [-keymap file] [-options 1,0] [-layouts printed,dvorak,colemak] [text files...]
+//
+// The keyboard and finger assignments default to the ones in the config
+// file, and the text to the built-in quotes.
+func runAnalyze(args []string) error {
+	config := DefaultConfig()
+	if path, err := configPath(); err == nil {
+		if err := loadThemes(filepath.Join(filepath.Dir(path), "themes")); err != nil {
+			return err
+		}
+		if err := loadLayouts(filepath.Join(filepath.Dir(path), "layouts")); err != nil {
+			return err
+		}
+		if loaded, err := LoadConfig(path); err == nil {
+			config = loaded
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+
+	flags := flag.NewFlagSet("analyze", flag.ContinueOnError)
+	keyboardFile := flags.String("keyboard", config.KeyboardFile, "keyboard `file` (KLE JSON, a QMK info.json or keymap.json, or a VIA definition)")
+	var opts KeyboardOptions
+	opts.addFlags(flags)
+	layoutList := flags.String("layouts", "", "comma separated layouts to compare, \"printed\" for the legends (default all)")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if *keyboardFile == "" {
+		return errors.New("no keyboard given, and no keyboard_file in the config")
+	}
+	kb, err := loadKeyboard(*keyboardFile, opts)
+	if err != nil {
+		return fmt.Errorf("failed to load keyboard: %w", err)
+	}
+	if kb.Warning != "" {
+		fmt.Fprintln(os.Stderr, "warning:", kb.Warning)
+	}
+	assignFingers(&kb, config.Fingers[kb.Meta.Name])
+
+	ids := analysisLayouts()
+	if *layoutList != "" {
+		ids = nil
+		for _, id := range strings.Split(*layoutList, ",") {
+			id = strings.TrimSpace(id)
+			if id == "printed" {
+				id = ""
+			}
+			if _, ok := layouts[id]; !ok && id != "" {
+				return fmt.Errorf("unknown layout %q, try one of printed, %s", id, strings.Join(layoutNames(), ", "))
+			}
+			ids = append(ids, id)
+		}
+	}
+
+	text := analysisCorpus()
+	if flags.NArg() > 0 {
+		var texts []string
+		for _, file := range flags.Args() {
+			data, err := os.ReadFile(file)
+			if err != nil {
+				return fmt.Errorf("failed to read text: %w", err)
+			}
+			texts = append(texts, string(data))
+		}
+		text = strings.Join(texts, " ")
+	}
+
+	fmt.Printf("Keyboard: %s (%d characters of text)\n\n", kb.Meta.Name, len([]rune(text)))
+	fmt.Print(formatAnalysis(kb, ids, text))
+	return nil
+}
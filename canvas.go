@@ -0,0 +1,124 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/charmbracelet/lipgloss"
+)
+
+// A fixed-size grid of terminal cells
+// Keys are drawn onto the canvas at their (scaled) KLE coordinates, which
+// lets us leave gaps and overlap rows in ways lipgloss joins can't.
+type canvas struct {
+	width  int
+	height int
+	cells  [][]canvasCell
+	styles []lipgloss.Style
+}
+
+type canvasCell struct {
+	char  string // empty for the second column of a wide character
+	style int    // index into canvas.styles, 0 = unstyled
+}
+
+func newCanvas(width, height int) *canvas {
+	c := &canvas{
+		width:  max(width, 0),
+		height: max(height, 0),
+		styles: []lipgloss.Style{lipgloss.NewStyle()},
+	}
+
+	c.cells = make([][]canvasCell, c.height)
+	for y := range c.cells {
+		c.cells[y] = make([]canvasCell, c.width)
+		for x := range c.cells[y] {
+			c.cells[y][x] = canvasCell{char: " "}
+		}
+	}
+
+	return c
+}
+
+// Register a style and return the handle used to draw with it
+func (c *canvas) addStyle(style lipgloss.Style) int {
+	c.styles = append(c.styles, style)
+	return len(c.styles) - 1
+}
+
+// Set a single cell, ignoring anything outside the canvas
+func (c *canvas) set(x, y int, char string, style int) {
+	if x < 0 || y < 0 || x >= c.width || y >= c.height {
+		return
+	}
+
+	// Don't leave half of a wide character behind
+	if c.cells[y][x].char == "" && x > 0 {
+		c.cells[y][x-1].char = " "
+	}
+	if lipgloss.Width(c.cells[y][x].char) > 1 && x+1 < c.width {
+		c.cells[y][x+1].char = " "
+	}
+
+	c.cells[y][x] = canvasCell{char: char, style: style}
+}
+
+// Fill a rectangle with blank cells of the given style
+func (c *canvas) fill(x, y, width, height, style int) {
+	for row := y; row < y+height; row++ {
+		for col := x; col < x+width; col++ {
+			c.set(col, row, " ", style)
+		}
+	}
+}
+
+// Write text starting at (x, y), clipped to maxWidth columns
+func (c *canvas) text(x, y int, s string, maxWidth, style int) {
+	col := 0
+	for _, r := range s {
+		char := string(r)
+		w := lipgloss.Width(char)
+		if w == 0 {
+			continue
+		}
+		if col+w > maxWidth {
+			break
+		}
+
+		c.set(x+col, y, char, style)
+		if w > 1 {
+			// Wide characters occupy the following cell as well
+			c.set(x+col+1, y, "", style)
+		}
+		col += w
+	}
+}
+
+// Render the canvas, grouping runs of equally styled cells
+func (c *canvas) String() string {
+	lines := make([]string, 0, c.height)
+
+	for _, row := range c.cells {
+		var line strings.Builder
+		start := 0
+		for x := 1; x <= len(row); x++ {
+			if x < len(row) && row[x].style == row[start].style {
+				continue
+			}
+
+			var run strings.Builder
+			for _, cell := range row[start:x] {
+				run.WriteString(cell.char)
+			}
+
+			if row[start].style == 0 {
+				line.WriteString(run.String())
+			} else {
+				line.WriteString(c.styles[row[start].style].Render(run.String()))
+			}
+			start = x
+		}
+		lines = append(lines, line.String())
+	}
+
+	return strings.Join(lines, "\n")
+}
@@ -1,8 +1,15 @@
 package main
 
 import (
+	"errors"
+	"flag"
 	"fmt"
+	"io"
+	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
+	"typr2/typing"
 
 	tea "github.com/charmbracelet/bubbletea"
 )
@@ -12,7 +19,8 @@ func (m Model) handleCommandMode(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 	switch msg.String() {
 	case "enter":
 		// Execute command
-		cmd := m.executeCommand(m.commandInput)
+		var cmd tea.Cmd
+		m, cmd = m.executeCommand(m.commandInput)
 		m.commandMode = NormalMode
 		m.commandInput = ""
 		return m, cmd
@@ -38,72 +46,441 @@ func (m Model) handleCommandMode(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 	}
 }
 
-// Execute command and return appropriate tea.Cmd
-func (m Model) executeCommand(command string) tea.Cmd {
+// Execute command and return the updated model and appropriate tea.Cmd
+func (m Model) executeCommand(command string) (Model, tea.Cmd) {
 	m.commandError = "" // Clear previous errors
 
 	switch command {
 	case "q", "quit":
-		return tea.Quit
+		return m, tea.Quit
 	case "start", "home":
-		return func() tea.Msg { return ScreenChangeMsg{StartScreen} }
+		return m, func() tea.Msg { return ScreenChangeMsg{StartScreen} }
 	case "main":
-		return func() tea.Msg { return ScreenChangeMsg{MainScreen} }
+		return m, func() tea.Msg { return ScreenChangeMsg{MainScreen} }
 	case "config", "settings":
-		return func() tea.Msg { return ScreenChangeMsg{ConfigScreen} }
+		return m, func() tea.Msg { return ScreenChangeMsg{ConfigScreen} }
 	case "extras":
-		return func() tea.Msg { return ScreenChangeMsg{ExtrasScreen} }
+		return m, func() tea.Msg { return ScreenChangeMsg{ExtrasScreen} }
 	case "resize":
 		// Force a window size check (useful for debugging)
-		return func() tea.Msg {
+		return m, func() tea.Msg {
 			return tea.WindowSizeMsg{Width: m.termWidth, Height: m.termHeight}
 		}
+	case "w", "write":
+		if err := m.saveConfig(); err != nil {
+			m.commandError = err.Error()
+		} else {
+			m.commandError = fmt.Sprintf("Config written to %s", m.configPath)
+		}
+		return m, nil
 	case "help":
-		m.commandError = "Commands: q|quit, start|home, main, config|settings, extras, resize, set"
-		return nil
+		m.commandError = "Commands: q|quit, start|home, main, config|settings, extras, resize, set, w|write, heatmap, lesson, source, mode"
+		return m, nil
 	default:
 		// Handle 'set' commands for configuration
 		if len(command) > 4 && command[:4] == "set " {
 			return m.handleSetCommand(command[4:])
 		}
 
+		if command == "lesson" || strings.HasPrefix(command, "lesson ") {
+			return m.handleLessonCommand(strings.TrimSpace(command[len("lesson"):]))
+		}
+
+		if command == "mode" || strings.HasPrefix(command, "mode ") {
+			return m.handleModeCommand(strings.TrimSpace(command[len("mode"):]))
+		}
+
+		if command == "source" || strings.HasPrefix(command, "source ") {
+			return m.handleSourceCommand(strings.TrimSpace(command[len("source"):]))
+		}
+
+		if command == "heatmap" || strings.HasPrefix(command, "heatmap ") {
+			return m.handleHeatmapCommand(strings.TrimSpace(command[len("heatmap"):]))
+		}
+
 		if command == "" {
-			return nil
+			return m, nil
 		}
 		m.commandError = fmt.Sprintf("Unknown command: %s (try 'help')", command)
-		return nil
+		return m, nil
+	}
+}
+
+// Handle 'heatmap' commands, with no argument cycling through the modes
+func (m Model) handleHeatmapCommand(arg string) (Model, tea.Cmd) {
+	switch arg {
+	case "":
+		m.heatmap = (m.heatmap + 1) % heatmapModeCount
+	case "off":
+		m.heatmap = heatmapOff
+	case "errors":
+		m.heatmap = heatmapErrors
+	case "latency", "speed":
+		m.heatmap = heatmapLatency
+	default:
+		m.commandError = "Usage: heatmap [errors|latency|off]"
+		return m, nil
+	}
+
+	m.commandError = fmt.Sprintf("Heatmap: %s", m.heatmap)
+	return m, nil
+}
+
+// Handle 'lesson' commands, with no argument listing the lessons
+// Lessons can be picked by ID or by their number in the list.
+func (m Model) handleLessonCommand(arg string) (Model, tea.Cmd) {
+	if arg == "" {
+		var ids []string
+		for i, lesson := range m.lessons {
+			ids = append(ids, fmt.Sprintf("%d:%s", i+1, lesson.ID))
+		}
+		m.commandError = fmt.Sprintf("Lessons: %s", strings.Join(ids, ", "))
+		return m, nil
+	}
+
+	i := findLesson(m.lessons, arg)
+	if n, err := strconv.Atoi(arg); err == nil && i < 0 {
+		i = n - 1
 	}
+	if i < 0 || i >= len(m.lessons) {
+		m.commandError = fmt.Sprintf("Unknown lesson: %s (try 'lesson' for a list)", arg)
+		return m, nil
+	}
+
+	if !m.lessonUnlocked(i) {
+		m.commandError = m.lockedReason(i)
+		return m, nil
+	}
+
+	m = m.startLesson(i)
+	m.commandError = fmt.Sprintf("Lesson: %s", m.lessons[i].Title)
+	return m, func() tea.Msg { return ScreenChangeMsg{MainScreen} }
+}
+
+// Handle 'mode' commands, with no argument showing the current mode
+// Changing the mode starts a fresh test (or prompt) from the current source.
+func (m Model) handleModeCommand(arg string) (Model, tea.Cmd) {
+	if arg == "" {
+		m.commandError = fmt.Sprintf("Mode: %s", m.mode)
+		return m, nil
+	}
+
+	mode, ok := parseTestMode(arg)
+	if !ok {
+		m.commandError = "Usage: mode prompt | time [15|30|60|120] | words [10|25|50|100] | zen"
+		return m, nil
+	}
+
+	m.mode = mode
+	m = m.nextPrompt()
+	m.commandError = fmt.Sprintf("Mode: %s", mode)
+	return m, func() tea.Msg { return ScreenChangeMsg{MainScreen} }
+}
+
+// Handle 'source' commands, with no argument showing the current source
+//
+//	source lesson               the current lesson
+//	source words [200|1k|10k]   common words (default 1k)
+//	source random [min] [max]   made up words of random letters
+//	source quotes [file]        quotes, one per line (default built-in)
+//	source text <file>          the sentences of a text file, in order
+func (m Model) handleSourceCommand(arg string) (Model, tea.Cmd) {
+	const usage = "Usage: source lesson | words [200|1k|10k] | random [min] [max] | quotes [file] | text <file>"
+
+	fields := strings.Fields(arg)
+	if len(fields) == 0 {
+		m.commandError = "Source: " + m.sourceName
+		return m, nil
+	}
+
+	// File names can have spaces in them
+	kind, args := fields[0], fields[1:]
+	path := strings.TrimSpace(strings.TrimPrefix(arg, kind))
+
+	var source PromptSource
+	var name string
+	switch {
+	case kind == "lesson" && len(args) == 0:
+		m = m.startLesson(m.lesson)
+		m.commandError = m.sourceName
+		return m, func() tea.Msg { return ScreenChangeMsg{MainScreen} }
+
+	case kind == "words" && len(args) <= 1:
+		list := "1k"
+		if len(args) == 1 {
+			list = args[0]
+		}
+		words, ok := wordLists[list]
+		if !ok {
+			m.commandError = "Usage: source words [200|1k|10k]"
+			return m, nil
+		}
+		source = &wordSource{words: words, rng: m.rng}
+		name = "Words: top " + list
+
+	case kind == "random" && len(args) <= 2:
+		lengths := []int{2, 7}
+		for i, arg := range args {
+			n, err := strconv.Atoi(arg)
+			if err != nil || n <= 0 {
+				m.commandError = "Usage: source random [min] [max] (word lengths)"
+				return m, nil
+			}
+			lengths[i] = n
+		}
+		if len(args) == 1 {
+			lengths[1] = max(lengths[0], lengths[1])
+		}
+		if lengths[0] > lengths[1] {
+			m.commandError = fmt.Sprintf("Shortest word can't be longer than the longest, got %d and %d", lengths[0], lengths[1])
+			return m, nil
+		}
+		source = &randomWordSource{
+			chars:     []rune("abcdefghijklmnopqrstuvwxyz"),
+			words:     sourceWords,
+			minLength: lengths[0],
+			maxLength: lengths[1],
+			rng:       m.rng,
+		}
+		name = fmt.Sprintf("Random words: %d-%d letters", lengths[0], lengths[1])
+
+	case kind == "quotes":
+		name = "Quotes"
+		if path != "" {
+			name = "Quotes: " + filepath.Base(path)
+		}
+		quotes, err := loadQuoteSource(path, m.rng)
+		if err != nil {
+			m.commandError = err.Error()
+			return m, nil
+		}
+		source = quotes
+
+	case kind == "text" && path != "":
+		text, err := loadTextSource(path)
+		if err != nil {
+			m.commandError = err.Error()
+			return m, nil
+		}
+		source = text
+		name = "Text: " + filepath.Base(path)
+
+	default:
+		m.commandError = usage
+		return m, nil
+	}
+
+	m = m.startSource(source, name)
+	m.sourceArgs = arg
+	m.commandError = name
+	return m, func() tea.Msg { return ScreenChangeMsg{MainScreen} }
 }
 
 // Handle 'set' commands for configuration
-func (m Model) handleSetCommand(args string) tea.Cmd {
-	parts := strings.Fields(args)
-	if len(parts) < 2 {
+// Valid changes are written straight back to the config file.
+func (m Model) handleSetCommand(args string) (Model, tea.Cmd) {
+	option, value, _ := strings.Cut(strings.TrimSpace(args), " ")
+	value = strings.TrimSpace(value)
+	if value == "" {
 		m.commandError = "Usage: set <option> <value> (try: set commandkey :)"
-		return nil
+		return m, nil
 	}
 
-	option := parts[0]
-	value := parts[1]
+	config := m.config
+	keyboard := m.keyboard
+	keyboardFile, keyboardOpts := m.keyboardFile, m.keyboardOpts
+	var message string
 
 	switch option {
 	case "commandkey":
-		if len(value) == 1 {
-			m.config.CommandKey = value
-			m.commandError = fmt.Sprintf("Command key set to '%s'", value)
-		} else {
-			m.commandError = "Command key must be a single character"
-		}
+		config.CommandKey = value
+		message = fmt.Sprintf("Command key set to '%s'", value)
 	case "searchkey":
-		if len(value) == 1 {
-			m.config.SearchKey = value
-			m.commandError = fmt.Sprintf("Search key set to '%s'", value)
-		} else {
-			m.commandError = "Search key must be a single character"
+		config.SearchKey = value
+		message = fmt.Sprintf("Search key set to '%s'", value)
+	case "keyboard":
+		file, opts, err := m.parseKeyboardArgs(value)
+		if err != nil {
+			m.commandError = err.Error()
+			return m, nil
+		}
+		kb, err := loadKeyboard(file, opts)
+		if err != nil {
+			m.commandError = fmt.Sprintf("Failed to load keyboard: %v", err)
+			return m, nil
+		}
+		assignFingers(&kb, config.Fingers[kb.Meta.Name])
+		applyLayout(&kb, layouts[config.Layout])
+		keyboard = kb
+		keyboardFile, keyboardOpts = file, opts
+		config.KeyboardFile = file
+		message = fmt.Sprintf("Keyboard set to '%s'", file)
+		if kb.Warning != "" {
+			message += ", but " + kb.Warning
+		}
+	case "theme":
+		config.Theme = value
+		message = fmt.Sprintf("Theme set to '%s'", value)
+	case "layout":
+		if value == "none" {
+			value = ""
+		}
+		layout, ok := layouts[value]
+		if !ok && value != "" {
+			m.commandError = fmt.Sprintf("Usage: set layout none|%s", strings.Join(layoutNames(), "|"))
+			return m, nil
+		}
+		// Copy on write, the keys are shared with models handed out earlier
+		keyboard.Keys = slices.Clone(keyboard.Keys)
+		applyLayout(&keyboard, layout)
+		config.Layout = value
+		message = "Layout set to what the legends say"
+		if value != "" {
+			message = fmt.Sprintf("Layout set to %s", layout.Name)
+		}
+	case "legends":
+		config.Legends = value
+		message = fmt.Sprintf("Legends set to %s", value)
+	case "autoadvance":
+		on, ok := parseSwitch(value)
+		if !ok {
+			m.commandError = "Usage: set autoadvance on|off"
+			return m, nil
+		}
+		config.Typing.AutoAdvance = on
+		message = fmt.Sprintf("Auto advance set to %s", value)
+	case "advancedelay":
+		delay, err := strconv.Atoi(value)
+		if err != nil {
+			m.commandError = "Usage: set advancedelay <milliseconds>"
+			return m, nil
+		}
+		config.Typing.AdvanceDelay = delay
+		message = fmt.Sprintf("Advance delay set to %dms", delay)
+	case "heatmap":
+		config.Typing.Heatmap = value
+		message = fmt.Sprintf("Heatmap set to %s", value)
+	case "hint":
+		on, ok := parseSwitch(value)
+		if !ok {
+			m.commandError = "Usage: set hint on|off"
+			return m, nil
+		}
+		config.Typing.Hint = on
+		message = fmt.Sprintf("Next key hint set to %s", value)
+	case "fingers":
+		on, ok := parseSwitch(value)
+		if !ok {
+			m.commandError = "Usage: set fingers on|off"
+			return m, nil
+		}
+		config.Typing.FingerColors = on
+		message = fmt.Sprintf("Finger colors set to %s", value)
+	case "drillseed":
+		seed, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			m.commandError = "Usage: set drillseed <number> (0 for random)"
+			return m, nil
+		}
+		config.Typing.DrillSeed = seed
+		message = fmt.Sprintf("Drill seed set to %d", seed)
+	case "errormode":
+		if _, ok := typing.ParseErrorMode(value); !ok {
+			m.commandError = "Usage: set errormode free|stop-on-error|stop-on-word|strict"
+			return m, nil
 		}
+		config.Typing.ErrorMode = value
+		message = fmt.Sprintf("Error mode set to %s", value)
 	default:
-		m.commandError = fmt.Sprintf("Unknown option: %s (try: commandkey, searchkey)", option)
+		m.commandError = fmt.Sprintf("Unknown option: %s (try: commandkey, searchkey, keyboard, theme, autoadvance, advancedelay, heatmap, hint, fingers, drillseed, errormode, layout, legends)", option)
+		return m, nil
+	}
+
+	if err := config.Validate(); err != nil {
+		m.commandError = err.Error()
+		return m, nil
+	}
+
+	m.config = config
+	m.keyboard = keyboard
+	m.keyboardFile, m.keyboardOpts = keyboardFile, keyboardOpts
+	ApplyTheme(themes[config.Theme])
+	m.heatmap, _ = parseHeatmapMode(config.Typing.Heatmap)
+	if option == "drillseed" {
+		// Start the source over, or its prompts keep coming from the old seed
+		m.rng = newRand(config.Typing.DrillSeed)
+		switch {
+		case m.lessonActive:
+			m = m.startLesson(m.lesson)
+		case m.sourceArgs != "":
+			m, _ = m.handleSourceCommand(m.sourceArgs)
+		}
+	}
+	if option == "layout" && m.lessonActive {
+		// Lessons that practice keys by position need new prompts
+		m = m.startLesson(m.lesson)
+	}
+	if option == "errormode" {
+		// Takes effect right away, even part way through a prompt
+		errorMode, _ := typing.ParseErrorMode(config.Typing.ErrorMode)
+		m.session.SetErrorMode(errorMode)
+	}
+	m.commandError = message
+
+	if err := m.saveConfig(); err != nil {
+		m.commandError = fmt.Sprintf("%s, but %v", message, err)
+	}
+
+	return m, nil
+}
+
+// Parse the arguments of set keyboard: a file, after the same options as
+// on the command line. Without any, the file is loaded the way it was last
+// time, if it's the current keyboard.
+func (m Model) parseKeyboardArgs(args string) (string, KeyboardOptions, error) {
+	const usage = "Usage: set keyboard [-format kle|qmk|via] [-keymap file] [-options 1,0] <file>"
+
+	if !strings.HasPrefix(args, "-") {
+		file := absPath(args)
+		if file == m.keyboardFile {
+			return file, m.keyboardOpts, nil
+		}
+		return file, KeyboardOptions{}, nil
 	}
 
-	return nil
+	var opts KeyboardOptions
+	flags := flag.NewFlagSet("keyboard", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	opts.addFlags(flags)
+	if err := flags.Parse(strings.Fields(args)); err != nil {
+		return "", opts, fmt.Errorf("%v (%s)", err, usage)
+	}
+	if flags.NArg() == 0 {
+		return "", opts, errors.New(usage)
+	}
+	// File names can have spaces in them
+	return absPath(strings.Join(flags.Args(), " ")), opts, nil
+}
+
+// Write the current config to the config file
+func (m Model) saveConfig() error {
+	if m.configPath == "" {
+		return errors.New("no config file location")
+	}
+	// Saving the defaults would lose whatever was in the broken file
+	if m.configErr != nil {
+		return fmt.Errorf("not saving over %s, which failed to load: fix it and restart", m.configPath)
+	}
+	return m.config.Save(m.configPath)
+}
+
+// Parse an on/off style option value
+func parseSwitch(value string) (on bool, ok bool) {
+	switch strings.ToLower(value) {
+	case "on", "true", "yes", "1":
+		return true, true
+	case "off", "false", "no", "0":
+		return false, true
+	}
+	return false, false
 }
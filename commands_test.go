@@ -0,0 +1,101 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestSetDrillSeedRestartsSource(t *testing.T) {
+	lessons := []Lesson{{ID: "drill", Title: "Drill", Drill: &LessonDrill{Words: 12}}}
+	tests := []struct {
+		name  string
+		start func(m Model) Model
+	}{
+		{"drill", func(m Model) Model { return m.startLesson(0) }},
+		{"words", func(m Model) Model { m, _ = m.handleSourceCommand("words 1k"); return m }},
+		{"random", func(m Model) Model { m, _ = m.handleSourceCommand("random"); return m }},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			m := Model{
+				config:      DefaultConfig(),
+				configPath:  filepath.Join(t.TempDir(), "config.json"),
+				lessons:     lessons,
+				rng:         newRand(0),
+				pressedKeys: make(map[int]int),
+			}
+			m = test.start(m)
+
+			m, _ = m.handleSetCommand("drillseed 42")
+			first := m.session.Target()
+			m = m.nextPrompt()
+			second := m.session.Target()
+
+			m, _ = m.handleSetCommand("drillseed 42")
+			if target := m.session.Target(); target != first {
+				t.Errorf("first prompt = %q after setting the seed again, want %q", target, first)
+			}
+			if target := m.nextPrompt().session.Target(); target != second {
+				t.Errorf("second prompt = %q after setting the seed again, want %q", target, second)
+			}
+		})
+	}
+}
+
+func TestParseKeyboardArgs(t *testing.T) {
+	m := Model{
+		keyboardFile: absPath("config/planck-keyboard.json"),
+		keyboardOpts: KeyboardOptions{Format: "kle"},
+	}
+	tests := []struct {
+		args string
+		file string
+		opts KeyboardOptions
+	}{
+		{"config/planck-keyboard.json", "config/planck-keyboard.json", KeyboardOptions{Format: "kle"}}, // Loaded as before
+		{"config/test-keyboard.json", "config/test-keyboard.json", KeyboardOptions{}},
+		{"-format via -keymap my.vil -options 1,0 my keyboard.json", "my keyboard.json",
+			KeyboardOptions{Format: "via", Keymap: "my.vil", LayoutOptions: []int{1, 0}}},
+	}
+	for _, test := range tests {
+		file, opts, err := m.parseKeyboardArgs(test.args)
+		if err != nil {
+			t.Errorf("parseKeyboardArgs(%q): %v", test.args, err)
+			continue
+		}
+		if file != absPath(test.file) || !reflect.DeepEqual(opts, test.opts) {
+			t.Errorf("parseKeyboardArgs(%q) = %q, %+v, want %q, %+v", test.args, file, opts, absPath(test.file), test.opts)
+		}
+	}
+
+	for _, args := range []string{"-format", "-format via", "-options x planck.json", "-nope planck.json"} {
+		if _, _, err := m.parseKeyboardArgs(args); err == nil {
+			t.Errorf("parseKeyboardArgs(%q) succeeded", args)
+		}
+	}
+}
+
+func TestSetKeyboardNeedsValidConfig(t *testing.T) {
+	m := Model{
+		config:       DefaultConfig(),
+		configPath:   filepath.Join(t.TempDir(), "config.json"),
+		keyboardFile: absPath("config/test-keyboard.json"),
+		pressedKeys:  make(map[int]int),
+	}
+
+	// A config that doesn't validate isn't changed, and neither is the file
+	// it would be saved with
+	m.config.Theme = "gone"
+	m, _ = m.handleSetCommand("keyboard -format kle config/planck-keyboard.json")
+	if m.keyboardFile != absPath("config/test-keyboard.json") || m.keyboardOpts.Format != "" || m.config.KeyboardFile != "" {
+		t.Errorf("keyboard file %q loaded with %+v after a failed set, want it unchanged", m.keyboardFile, m.keyboardOpts)
+	}
+
+	m.config.Theme = "default"
+	m, _ = m.handleSetCommand("keyboard -format kle config/planck-keyboard.json")
+	if want := absPath("config/planck-keyboard.json"); m.keyboardFile != want || m.keyboardOpts.Format != "kle" || m.config.KeyboardFile != want {
+		t.Errorf("keyboard file %q loaded with %+v, want %q with kle (%s)", m.keyboardFile, m.keyboardOpts, want, m.commandError)
+	}
+}
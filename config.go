@@ -1,9 +1,41 @@
 package main
 
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"typr2/typing"
+
+	"github.com/yosuke-furukawa/json5/encoding/json5"
+)
+
 // Application configuration
 type Config struct {
-	CommandKey string // Key to enter command mode (default ":")
-	SearchKey  string // Key to enter search mode (default "/")
+	CommandKey   string       `json:"command_key"`   // Key to enter command mode (default ":")
+	SearchKey    string       `json:"search_key"`    // Key to enter search mode (default "/")
+	KeyboardFile string       `json:"keyboard_file"` // Keyboard layout used when none is given on the command line
+	Theme        string       `json:"theme"`         // Name of the color theme
+	Layout       string       `json:"layout"`        // Logical layout typed on the keyboard, empty to go by its legends
+	Legends      string       `json:"legends"`       // Legends on the onscreen keyboard: printed, or layout for what the keys type
+	Typing       TypingConfig `json:"typing"`
+
+	// Finger assignments (key code to finger name) by keyboard name, for
+	// keys not pressed with the usual touch typing finger
+	Fingers map[string]map[string]string `json:"fingers,omitempty"`
+}
+
+// Options for the typing screen
+type TypingConfig struct {
+	AutoAdvance  bool   `json:"auto_advance"`     // Move on to the next prompt after completing one
+	AdvanceDelay int    `json:"advance_delay_ms"` // How long to show the results before moving on
+	Heatmap      string `json:"heatmap"`          // Keyboard heatmap shown at startup (off, errors, latency)
+	Hint         bool   `json:"hint"`             // Highlight the key(s) for the next character
+	FingerColors bool   `json:"finger_colors"`    // Color keys by the finger that should press them
+	DrillSeed    uint64 `json:"drill_seed"`       // Same seed, same made up prompts and drills (0 for random)
+	ErrorMode    string `json:"error_mode"`       // How mistakes are handled (free, stop-on-error, stop-on-word, strict)
 }
 
 // Default configuration
@@ -11,10 +43,104 @@ func DefaultConfig() Config {
 	return Config{
 		CommandKey: ":",
 		SearchKey:  "/",
+		Theme:      "default",
+		Legends:    "layout",
+		Typing: TypingConfig{
+			AutoAdvance:  true,
+			AdvanceDelay: 1000,
+			Heatmap:      "off",
+			Hint:         true,
+			FingerColors: false,
+			ErrorMode:    "free",
+		},
+	}
+}
+
+// Load configuration from a JSON (or JSON5) file
+// Options missing from the file keep their default values. The returned
+// config is only meaningful if the error is nil.
+func LoadConfig(filename string) (Config, error) {
+	config := DefaultConfig()
+
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return config, fmt.Errorf("failed to read config: %w", err)
+	}
+
+	if err := json5.Unmarshal(data, &config); err != nil {
+		return config, fmt.Errorf("failed to parse config %s: %w", filename, err)
+	}
+
+	if err := config.Validate(); err != nil {
+		return config, fmt.Errorf("invalid config %s: %w", filename, err)
 	}
+
+	return config, nil
 }
 
-// TODO: Add configuration loading/saving methods here
-// func LoadConfig(filename string) (Config, error) { ... }
-// func (c Config) Save(filename string) error { ... }
-// func (c Config) Validate() error { ... }
+// Write the configuration to a file, creating its directory if needed
+func (c Config) Save(filename string) error {
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode config: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(filename), 0o755); err != nil {
+		return fmt.Errorf("failed to create config directory: %w", err)
+	}
+
+	// Write to a temporary file first so a failed write can't leave a
+	// half-written config behind
+	tmp := filename + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("failed to write config: %w", err)
+	}
+	if err := os.Rename(tmp, filename); err != nil {
+		return fmt.Errorf("failed to write config: %w", err)
+	}
+
+	return nil
+}
+
+// Check the configuration for invalid values, reporting all of them
+func (c Config) Validate() error {
+	var errs []error
+
+	if len([]rune(c.CommandKey)) != 1 {
+		errs = append(errs, fmt.Errorf("command_key must be a single character, got %q", c.CommandKey))
+	}
+	if len([]rune(c.SearchKey)) != 1 {
+		errs = append(errs, fmt.Errorf("search_key must be a single character, got %q", c.SearchKey))
+	}
+	if c.CommandKey == c.SearchKey {
+		errs = append(errs, fmt.Errorf("command_key and search_key can't both be %q", c.CommandKey))
+	}
+
+	if _, ok := themes[c.Theme]; !ok {
+		errs = append(errs, fmt.Errorf("theme must be one of %s, got %q", strings.Join(themeNames(), ", "), c.Theme))
+	}
+	if _, ok := layouts[c.Layout]; !ok && c.Layout != "" {
+		errs = append(errs, fmt.Errorf("layout must be empty or one of %s, got %q", strings.Join(layoutNames(), ", "), c.Layout))
+	}
+	if c.Legends != "printed" && c.Legends != "layout" {
+		errs = append(errs, fmt.Errorf("legends must be printed or layout, got %q", c.Legends))
+	}
+
+	if c.Typing.AdvanceDelay < 0 {
+		errs = append(errs, fmt.Errorf("typing.advance_delay_ms can't be negative, got %d", c.Typing.AdvanceDelay))
+	}
+	if _, ok := parseHeatmapMode(c.Typing.Heatmap); !ok {
+		errs = append(errs, fmt.Errorf("typing.heatmap must be one of off, errors, latency, got %q", c.Typing.Heatmap))
+	}
+	if _, ok := typing.ParseErrorMode(c.Typing.ErrorMode); !ok {
+		errs = append(errs, fmt.Errorf("typing.error_mode must be one of free, stop-on-error, stop-on-word, strict, got %q", c.Typing.ErrorMode))
+	}
+
+	for keyboard, fingers := range c.Fingers {
+		if err := validateFingerMap(keyboard, fingers); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadConfigKeepsMissingKeyboardFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	data := `{"keyboard_file": "/nowhere/keyboard.json", "theme": "default", "typing": {"heatmap": "errors"}}`
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+	if config.KeyboardFile != "/nowhere/keyboard.json" || config.Typing.Heatmap != "errors" {
+		t.Errorf("settings not kept: keyboard_file %q, heatmap %q", config.KeyboardFile, config.Typing.Heatmap)
+	}
+}
+
+func TestSaveConfigKeepsBrokenConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte("{broken"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	_, loadErr := LoadConfig(path)
+	if loadErr == nil {
+		t.Fatal("LoadConfig of a broken file succeeded")
+	}
+
+	m := Model{config: DefaultConfig(), configPath: path, configErr: loadErr}
+	if err := m.saveConfig(); err == nil {
+		t.Error("saveConfig saved over a config that failed to load")
+	}
+	if data, _ := os.ReadFile(path); string(data) != "{broken" {
+		t.Errorf("config file changed to %q", data)
+	}
+}
+
+func TestStartupErrorsAreAllShown(t *testing.T) {
+	configDir := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", configDir)
+	t.Setenv("XDG_DATA_HOME", t.TempDir())
+
+	dir := filepath.Join(configDir, appName)
+	for file, data := range map[string]string{
+		"config.json":        "{broken",
+		"themes/bad.json":    "{broken",
+		"layouts/worse.json": "{broken",
+	} {
+		file = filepath.Join(dir, file)
+		if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(file, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	m := InitialModel("config/test-keyboard.json", KeyboardOptions{})
+	for _, want := range []string{"Failed to load themes", "Failed to load layouts", "Using default config"} {
+		if !strings.Contains(m.commandError, want) {
+			t.Errorf("startup message %q doesn't say %q", m.commandError, want)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"typr2/history"
+)
+
+// The touch typing course is made up of the lessons marked as part of it,
+// in lesson order. Each course lesson unlocks once the one before it is
+// passed, while other lessons are free practice and always open.
+
+// IDs of the lessons passed in any recorded session
+// Tests (timed, word count and zen) record the lesson they took their words
+// from, but only count as practice, the same as while the app is running.
+func loadPassedLessons(store *history.Store, lessons []Lesson) (map[string]bool, error) {
+	passed := make(map[string]bool)
+	if store == nil {
+		return passed, nil
+	}
+
+	entries, err := store.Query(history.Filter{})
+	if err != nil {
+		return passed, err
+	}
+	for _, e := range entries {
+		if e.Mode != "" {
+			continue
+		}
+		i := findLesson(lessons, e.Lesson)
+		if i >= 0 && lessons[i].Pass.Passed(e.NetWPM, e.Accuracy) {
+			passed[e.Lesson] = true
+		}
+	}
+
+	return passed, nil
+}
+
+// Whether a lesson can be started
+func (m Model) lessonUnlocked(lesson int) bool {
+	if !m.lessons[lesson].Course {
+		return true
+	}
+	if prev := m.courseLessonBefore(lesson); prev >= 0 {
+		return m.passedLessons[m.lessons[prev].ID]
+	}
+	return true
+}
+
+// The course lesson before the given one, or -1 if it's the first
+func (m Model) courseLessonBefore(lesson int) int {
+	for i := lesson - 1; i >= 0; i-- {
+		if m.lessons[i].Course {
+			return i
+		}
+	}
+	return -1
+}
+
+// The course lesson after the given one, or -1 if it's the last
+func (m Model) courseLessonAfter(lesson int) int {
+	for i := lesson + 1; i < len(m.lessons); i++ {
+		if m.lessons[i].Course {
+			return i
+		}
+	}
+	return -1
+}
+
+// Where to pick the course up: the first unlocked course lesson that isn't
+// passed yet, or the first lesson if there's none
+func (m Model) resumeLesson() int {
+	for i, lesson := range m.lessons {
+		if lesson.Course && !m.passedLessons[lesson.ID] && m.lessonUnlocked(i) {
+			return i
+		}
+	}
+	return 0
+}
+
+// Mark the current lesson as passed, returning a message about it if this
+// is the first time
+func (m Model) passLesson() (Model, string) {
+	lesson := m.lessons[m.lesson]
+	if m.passedLessons[lesson.ID] {
+		return m, ""
+	}
+
+	// Copy on write, like the pressed keys
+	passed := maps.Clone(m.passedLessons)
+	passed[lesson.ID] = true
+	m.passedLessons = passed
+
+	if !lesson.Course {
+		return m, "Passed " + lesson.Title + "!"
+	}
+	if next := m.courseLessonAfter(m.lesson); next >= 0 {
+		return m, "Passed " + lesson.Title + "! Unlocked " + m.lessons[next].Title
+	}
+	return m, "Passed " + lesson.Title + "! That's the whole course"
+}
+
+// Marker for a lesson on the course map
+func (m Model) lessonStatus(lesson int) string {
+	switch {
+	case m.passedLessons[m.lessons[lesson].ID]:
+		return "✓"
+	case !m.lessonUnlocked(lesson):
+		return "🔒"
+	}
+	return "○"
+}
+
+// Why a lesson can't be started yet
+func (m Model) lockedReason(lesson int) string {
+	return fmt.Sprintf("Locked: pass %s first", m.lessons[m.courseLessonBefore(lesson)].Title)
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+	"typr2/history"
+)
+
+func TestLoadPassedLessonsIgnoresTests(t *testing.T) {
+	store, err := history.Open(filepath.Join(t.TempDir(), "history.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	lessons := []Lesson{
+		{ID: "home", Course: true, Pass: PassCriteria{WPM: 15, Accuracy: 95}},
+		{ID: "top", Course: true, Pass: PassCriteria{WPM: 15, Accuracy: 95}},
+	}
+
+	entries := []history.Entry{
+		{Time: time.Now(), Lesson: "home", NetWPM: 40, Accuracy: 100},
+		{Time: time.Now(), Lesson: "top", Mode: "time 30", NetWPM: 40, Accuracy: 100},
+	}
+	for _, e := range entries {
+		if err := store.Add(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	passed, err := loadPassedLessons(store, lessons)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !passed["home"] {
+		t.Error("home not passed by a prompt that met its criteria")
+	}
+	if passed["top"] {
+		t.Error("top passed by a timed test")
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"cmp"
+	"maps"
+	"math"
+	"math/rand/v2"
+	"slices"
+	"strings"
+	"time"
+	"typr2/typing"
+	"unicode"
+)
+
+// Settings for drills made up from the user's weakest keys and bigrams
+type LessonDrill struct {
+	Words int `json:"words"` // Words per prompt
+}
+
+const (
+	drillMinSamples = 5 // Keystrokes needed before a character or bigram counts
+	drillFocus      = 5 // How many of the weakest characters and bigrams to drill
+)
+
+// How much the weakest characters (or bigrams) need practice
+// Error rate and mean latency both count, each relative to the worst one,
+// for a score between 0 and 2. Only the drillFocus weakest are kept, so
+// the drill isn't spread thin over everything that's a bit slow.
+func weakSpots(stats typing.KeyStats) map[string]float64 {
+	var maxErrors float64
+	var maxLatency time.Duration
+	var keys []string
+	for key, stat := range stats {
+		if stat.Hits+stat.Misses < drillMinSamples || strings.ContainsFunc(key, unicode.IsSpace) {
+			continue
+		}
+		keys = append(keys, key)
+		maxErrors = max(maxErrors, stat.ErrorRate())
+		maxLatency = max(maxLatency, stat.MeanLatency())
+	}
+
+	scores := make(map[string]float64, len(keys))
+	for _, key := range keys {
+		var score float64
+		if maxErrors > 0 {
+			score += stats[key].ErrorRate() / maxErrors
+		}
+		if maxLatency > 0 {
+			score += float64(stats[key].MeanLatency()) / float64(maxLatency)
+		}
+		// Words are lowercase, so fold the case of letters
+		key = strings.ToLower(key)
+		scores[key] = max(scores[key], score)
+	}
+
+	// Sort by score, then by key so the same stats always drill the same keys
+	keys = slices.Collect(maps.Keys(scores))
+	slices.SortFunc(keys, func(a, b string) int {
+		return cmp.Or(cmp.Compare(scores[b], scores[a]), strings.Compare(a, b))
+	})
+	for _, key := range keys[min(drillFocus, len(keys)):] {
+		delete(scores, key)
+	}
+
+	return scores
+}
+
+// Drill prompts, made up from common words that contain the weak spots
+// The statistics are shared with the model, so every prompt drills the
+// weak spots as they are after the last one.
+type drillSource struct {
+	drill   LessonDrill
+	keys    typing.KeyStats
+	bigrams typing.KeyStats
+	rng     *rand.Rand
+}
+
+// Every word can come up, but each weak character in it makes it more
+// likely, and each weak bigram more so. Without enough statistics yet it's
+// just random common words.
+func (s *drillSource) Next() string {
+	chars, bigrams := weakSpots(s.keys), weakSpots(s.bigrams)
+	drillWords := wordLists["1k"]
+
+	weights := make([]float64, len(drillWords))
+	var total float64
+	for i, word := range drillWords {
+		var score float64
+		runes := []rune(word)
+		for j, r := range runes {
+			score += chars[string(r)]
+			if j > 0 {
+				score += 2 * bigrams[string(runes[j-1:j+1])]
+			}
+		}
+		// Cubed, or the few words with a rare weak letter like "q" would
+		// drown in all the others
+		weights[i] = math.Pow(1+score, 3)
+		total += weights[i]
+	}
+
+	words := make([]string, s.drill.Words)
+	for i := range words {
+		pick := s.rng.Float64() * total
+		for j, weight := range weights {
+			if pick -= weight; pick < 0 || j == len(weights)-1 {
+				words[i] = drillWords[j]
+				break
+			}
+		}
+	}
+
+	return strings.Join(words, " ")
+}
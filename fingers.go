@@ -0,0 +1,217 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"typr2/typing"
+	"unicode/utf8"
+
+	"github.com/charmbracelet/lipgloss"
+)
+
+// The finger that should press a key
+type Finger int
+
+const (
+	NoFinger Finger = iota
+	LeftPinky
+	LeftRing
+	LeftMiddle
+	LeftIndex
+	LeftThumb
+	RightThumb
+	RightIndex
+	RightMiddle
+	RightRing
+	RightPinky
+)
+
+// Names used for fingers in the config file
+var fingerNames = []string{
+	NoFinger:    "none",
+	LeftPinky:   "left-pinky",
+	LeftRing:    "left-ring",
+	LeftMiddle:  "left-middle",
+	LeftIndex:   "left-index",
+	LeftThumb:   "left-thumb",
+	RightThumb:  "right-thumb",
+	RightIndex:  "right-index",
+	RightMiddle: "right-middle",
+	RightRing:   "right-ring",
+	RightPinky:  "right-pinky",
+}
+
+func (f Finger) String() string {
+	if f < 0 || int(f) >= len(fingerNames) {
+		return fingerNames[NoFinger]
+	}
+	return fingerNames[f]
+}
+
+// Parse a finger name from the config file
+func parseFinger(name string) (Finger, bool) {
+	i := slices.Index(fingerNames, strings.ToLower(name))
+	return Finger(i), i >= 0
+}
+
+// Which hand the finger belongs to
+type Hand int
+
+const (
+	NoHand Hand = iota
+	LeftHand
+	RightHand
+)
+
+func (f Finger) Hand() Hand {
+	switch {
+	case f >= LeftPinky && f <= LeftThumb:
+		return LeftHand
+	case f >= RightThumb && f <= RightPinky:
+		return RightHand
+	}
+	return NoHand
+}
+
+// Fingers for the standard touch typing positions, by key code
+var defaultFingers = map[string]Finger{}
+
+func init() {
+	assign := func(finger Finger, codes ...string) {
+		for _, code := range codes {
+			defaultFingers[code] = finger
+		}
+	}
+
+	assign(LeftPinky, "KC_ESC", "KC_GRV", "KC_1", "KC_TAB", "KC_Q", "KC_CAPS", "KC_A", "KC_LSFT", "KC_Z", "KC_LCTL")
+	assign(LeftRing, "KC_2", "KC_W", "KC_S", "KC_X")
+	assign(LeftMiddle, "KC_3", "KC_E", "KC_D", "KC_C")
+	assign(LeftIndex, "KC_4", "KC_5", "KC_R", "KC_T", "KC_F", "KC_G", "KC_V", "KC_B")
+	assign(LeftThumb, "KC_LALT", "KC_LGUI")
+	assign(RightThumb, "KC_SPC", "KC_RALT", "KC_RGUI")
+	assign(RightIndex, "KC_6", "KC_7", "KC_Y", "KC_U", "KC_H", "KC_J", "KC_N", "KC_M")
+	assign(RightMiddle, "KC_8", "KC_I", "KC_K", "KC_COMM")
+	assign(RightRing, "KC_9", "KC_O", "KC_L", "KC_DOT")
+	assign(RightPinky, "KC_0", "KC_MINS", "KC_EQL", "KC_BSPC", "KC_P", "KC_LBRC", "KC_RBRC", "KC_BSLS",
+		"KC_SCLN", "KC_QUOT", "KC_ENT", "KC_SLSH", "KC_RSFT", "KC_RCTL", "KC_APP")
+}
+
+// Assign a finger to every key on the keyboard
+// Standard keys get the usual touch typing finger, layer keys the thumb
+// (where they usually are on keyboards with layers) and anything else the
+// pinky of the hand on its side of the keyboard. The overrides map key codes
+// to finger names, as set per keyboard in the config file.
+func assignFingers(kb *Keyboard, overrides map[string]string) {
+	minX, _, maxX, _ := kb.Bounds()
+	center := (minX + maxX) / 2
+
+	for i := range kb.Keys {
+		key := &kb.Keys[i]
+
+		finger, ok := defaultFingers[key.Identity.Code]
+		if !ok {
+			left := keyCenterX(*key) < center
+			switch {
+			case key.Identity.IsLayerKey() && left:
+				finger = LeftThumb
+			case key.Identity.IsLayerKey():
+				finger = RightThumb
+			case left:
+				finger = LeftPinky
+			default:
+				finger = RightPinky
+			}
+		}
+
+		if name, ok := overrides[key.Identity.Code]; ok {
+			finger, _ = parseFinger(name)
+		}
+
+		key.Finger = finger
+	}
+}
+
+// Check a finger map from the config file
+func validateFingerMap(keyboard string, fingers map[string]string) error {
+	for code, name := range fingers {
+		if _, ok := parseFinger(name); !ok {
+			return fmt.Errorf("fingers.%s.%s must be one of %s, got %q", keyboard, code, strings.Join(fingerNames, ", "), name)
+		}
+	}
+	return nil
+}
+
+// Theme color for a finger, if it has one
+func fingerColor(f Finger) (lipgloss.Color, bool) {
+	switch f.Hand() {
+	case LeftHand:
+		return currentTheme.Fingers[f-LeftPinky], true
+	case RightHand:
+		return currentTheme.Fingers[RightPinky-f], true
+	}
+	return "", false
+}
+
+// Keys (by index) to press for the next character of the prompt
+// Mistakes have to be fixed before going on, so after one that's Backspace,
+// unless the error mode keeps the cursor in place or doesn't allow fixing.
+func (m Model) hintKeys() []int {
+	if !m.config.Typing.Hint || m.session.Done() {
+		return nil
+	}
+
+	snapshot := m.session.Snapshot()
+	fixable := snapshot.ErrorMode == typing.ErrorFree || snapshot.ErrorMode == typing.StopOnWord
+	if fixable && slices.Contains(snapshot.States, typing.Incorrect) {
+		if key := m.keyboard.KeyByCode("KC_BSPC"); key >= 0 {
+			return []int{key}
+		}
+		return nil
+	}
+
+	if snapshot.Cursor >= len(snapshot.Target) {
+		return nil
+	}
+	return m.keyboard.KeysForGrapheme(snapshot.Target[snapshot.Cursor])
+}
+
+// The layer the next character of the prompt is on, 0 for the base layer
+// That's the layer the onscreen keyboard shows.
+func (m Model) activeLayer() int {
+	if len(m.keyboard.Layers) == 0 || m.session.Done() {
+		return 0
+	}
+
+	snapshot := m.session.Snapshot()
+	if snapshot.Cursor >= len(snapshot.Target) || slices.Contains(snapshot.States, typing.Incorrect) {
+		return 0
+	}
+	r, size := utf8.DecodeRuneInString(snapshot.Target[snapshot.Cursor])
+	if size != len(snapshot.Target[snapshot.Cursor]) {
+		return 0
+	}
+	return m.keyboard.LayerFor(r)
+}
+
+// Which keys to press next with which fingers, e.g. "Next: A (left pinky)"
+// Characters on other layers need the layer key too, e.g.
+// "Next: [ (right ring) + Raise (left thumb)".
+func (m Model) fingerGuide() string {
+	layer := m.activeLayer()
+	var parts []string
+	for _, i := range m.hintKeys() {
+		key := m.displayKey(m.keyboard.Keys[i], layer)
+		legend := primaryLegend(key)
+		if key.Identity.Code == "KC_SPC" {
+			legend = "Space"
+		}
+		finger := strings.ReplaceAll(key.Finger.String(), "-", " ")
+		parts = append(parts, fmt.Sprintf("%s (%s)", legend, finger))
+	}
+
+	if len(parts) == 0 {
+		return ""
+	}
+	return "Next: " + strings.Join(parts, " + ")
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"typr2/history"
+	"typr2/typing"
+
+	"github.com/charmbracelet/lipgloss"
+)
+
+// What the keyboard heatmap colors keys by
+type heatmapMode int
+
+const (
+	heatmapOff     heatmapMode = iota
+	heatmapErrors              // Error rate per key
+	heatmapLatency             // Mean time to reach the key
+	heatmapModeCount
+)
+
+func (h heatmapMode) String() string {
+	switch h {
+	case heatmapErrors:
+		return "errors"
+	case heatmapLatency:
+		return "latency"
+	default:
+		return "off"
+	}
+}
+
+// A good value for the mode, and how much worse than that a key has to be
+// to be the worst
+func (h heatmapMode) scale() (good, span float64) {
+	switch h {
+	case heatmapErrors:
+		return 0, 0.05 // 1 in 20 wrong
+	case heatmapLatency:
+		return 0.15, 0.25 // Seconds, 80 and 30 WPM
+	}
+	return 0, 1
+}
+
+// Parse the name of a heatmap mode
+func parseHeatmapMode(name string) (heatmapMode, bool) {
+	switch name {
+	case "", "off":
+		return heatmapOff, true
+	case "errors":
+		return heatmapErrors, true
+	case "latency", "speed":
+		return heatmapLatency, true
+	}
+	return heatmapOff, false
+}
+
+// Combine the per-key and per-bigram statistics of all sessions in the history
+func loadKeyStats(store *history.Store) (keys, bigrams typing.KeyStats, err error) {
+	keys, bigrams = make(typing.KeyStats), make(typing.KeyStats)
+	if store == nil {
+		return keys, bigrams, nil
+	}
+
+	entries, err := store.Query(history.Filter{})
+	if err != nil {
+		return keys, bigrams, err
+	}
+	for _, e := range entries {
+		keys.Merge(e.KeyStats)
+		bigrams.Merge(e.BigramStats)
+	}
+
+	return keys, bigrams, nil
+}
+
+// The characters a key types, with and without Shift
+func keyChars(key Key) []string {
+	var chars []string
+	if id := key.Identity; id.Unshifted != 0 {
+		chars = append(chars, string(id.Unshifted))
+		if id.Shifted != id.Unshifted && id.Shifted != 0 {
+			chars = append(chars, string(id.Shifted))
+		}
+	}
+	return chars
+}
+
+// Heat of each key (by index) from 0 (best) to 1 (worst) for the current
+// heatmap mode. Keys without any statistics are left out.
+func (m Model) heatmapValues() map[int]float64 {
+	values := make(map[int]float64)
+	if m.heatmap == heatmapOff {
+		return values
+	}
+
+	for i, key := range m.keyboard.Keys {
+		var stat typing.KeyStat
+		for _, char := range keyChars(key) {
+			stat = stat.Add(m.keyStats[char])
+		}
+
+		switch m.heatmap {
+		case heatmapErrors:
+			if stat.Hits+stat.Misses > 0 {
+				values[i] = stat.ErrorRate()
+			}
+		case heatmapLatency:
+			if stat.Timed > 0 {
+				values[i] = stat.MeanLatency().Seconds()
+			}
+		}
+	}
+
+	// Scale from the best key to the worst, so weak spots stand out even
+	// when overall accuracy is high, but over at least the mode's span from
+	// a good value, so keys that are all equally bad still show it
+	good, span := m.heatmap.scale()
+	low, high := good, math.Inf(-1)
+	for _, v := range values {
+		low = math.Min(low, v)
+		high = math.Max(high, v)
+	}
+	high = math.Max(high, low+span)
+	for i, v := range values {
+		values[i] = (v - low) / (high - low)
+	}
+
+	return values
+}
+
+// Color on a green (0) to yellow to red (1) scale
+func heatColor(heat float64) lipgloss.Color {
+	heat = math.Max(0, math.Min(1, heat))
+
+	r, g := 220.0, 200.0
+	if heat < 0.5 {
+		r *= heat * 2
+	} else {
+		g *= (1 - heat) * 2
+	}
+
+	return lipgloss.Color(fmt.Sprintf("#%02x%02x00", int(r), int(g)))
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"typr2/typing"
+)
+
+func TestHeatmapValues(t *testing.T) {
+	keys := []Key{
+		{Identity: KeyIdentity{Unshifted: 'a', Shifted: 'A'}},
+		{Identity: KeyIdentity{Unshifted: 'b', Shifted: 'B'}},
+		{Identity: KeyIdentity{Unshifted: 'c', Shifted: 'C'}},
+		{Identity: KeyIdentity{Code: "KC_ESC"}}, // Never typed
+	}
+	errors := func(misses ...int) typing.KeyStats {
+		stats := make(typing.KeyStats)
+		for i, n := range misses {
+			stats[string(rune('a'+i))] = typing.KeyStat{Hits: 100 - n, Misses: n}
+		}
+		return stats
+	}
+	latency := func(millis ...int) typing.KeyStats {
+		stats := make(typing.KeyStats)
+		for i, ms := range millis {
+			stats[string(rune('a'+i))] = typing.KeyStat{Hits: 10, Latency: 10 * time.Duration(ms) * time.Millisecond, Timed: 10}
+		}
+		return stats
+	}
+
+	tests := []struct {
+		name  string
+		mode  heatmapMode
+		stats typing.KeyStats
+		want  []float64 // By key, -1 for none
+	}{
+		{"no errors", heatmapErrors, errors(0, 0, 0), []float64{0, 0, 0, -1}},
+		{"all bad", heatmapErrors, errors(30, 30, 30), []float64{1, 1, 1, -1}},
+		{"one bad", heatmapErrors, errors(20), []float64{1, -1, -1, -1}},
+		{"a weak spot", heatmapErrors, errors(0, 1, 10), []float64{0, 0.1, 1, -1}},
+		{"all good", heatmapErrors, errors(1, 2, 0), []float64{0.2, 0.4, 0, -1}},
+		{"shifted", heatmapErrors, typing.KeyStats{"a": {Hits: 10}, "A": {Hits: 5, Misses: 5}}, []float64{1, -1, -1, -1}},
+		{"all fast", heatmapLatency, latency(100, 100, 100), []float64{0, 0, 0, -1}},
+		{"all slow", heatmapLatency, latency(600, 600, 600), []float64{1, 1, 1, -1}},
+		{"one slow", heatmapLatency, latency(150, 275, 1000), []float64{0, 0.125 / 0.85, 1, -1}},
+		{"off", heatmapOff, errors(30, 30, 30), []float64{-1, -1, -1, -1}},
+	}
+	for _, test := range tests {
+		m := Model{heatmap: test.mode, keyboard: Keyboard{Keys: keys}, keyStats: test.stats}
+		values := m.heatmapValues()
+		for i, want := range test.want {
+			got, ok := values[i]
+			if !ok {
+				got = -1
+			}
+			if math.Abs(got-want) > 1e-9 {
+				t.Errorf("%s: key %d heat = %g, want %g", test.name, i, got, want)
+			}
+		}
+	}
+}
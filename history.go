@@ -0,0 +1,82 @@
+package main
+
+import (
+	"log"
+	"path/filepath"
+	"strings"
+	"time"
+	"typr2/history"
+	"typr2/typing"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// Open the history store in the data directory
+func openHistory() (*history.Store, error) {
+	dir, err := dataDir()
+	if err != nil {
+		return nil, err
+	}
+
+	return history.Open(filepath.Join(dir, "history.jsonl"))
+}
+
+// Build a history entry for a completed typing session
+func (m Model) newHistoryEntry(snapshot typing.Snapshot) history.Entry {
+	stats := snapshot.Stats(snapshot.End)
+
+	keyErrors := make(map[string]int)
+	for _, k := range snapshot.Keystrokes {
+		if !k.Correct() {
+			keyErrors[k.Expected]++
+		}
+	}
+
+	// Only lessons are recorded, other prompt sources are free practice
+	var lesson string
+	if m.lessonActive {
+		lesson = m.lessons[m.lesson].ID
+	}
+
+	var mode string
+	if m.mode.Kind != modePrompt {
+		mode = m.mode.String()
+	}
+
+	// Zen has no target, so there's nothing right or wrong to learn from
+	var keyStats, bigramStats typing.KeyStats
+	if !snapshot.Free {
+		keyStats, bigramStats = snapshot.KeyStats(), snapshot.BigramStats()
+	}
+
+	return history.Entry{
+		Time:        snapshot.End,
+		Keyboard:    m.keyboard.Meta.Name,
+		Lesson:      lesson,
+		Mode:        mode,
+		Prompt:      strings.Join(snapshot.Target[:snapshot.Cursor], ""), // Timed tests end part way
+		WPM:         stats.GrossWPM,
+		NetWPM:      stats.NetWPM,
+		Accuracy:    stats.Accuracy,
+		Errors:      stats.Errors,
+		KeyErrors:   keyErrors,
+		KeyStats:    keyStats,
+		BigramStats: bigramStats,
+		Duration:    stats.Duration.Round(time.Millisecond),
+	}
+}
+
+// Save an entry to the history store in the background
+func (m Model) saveHistory(entry history.Entry) tea.Cmd {
+	store := m.history
+	if store == nil {
+		return nil
+	}
+
+	return func() tea.Msg {
+		if err := store.Add(entry); err != nil {
+			log.Printf("Error saving history: %v", err)
+		}
+		return nil
+	}
+}
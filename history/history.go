@@ -0,0 +1,135 @@
+// Package history stores the results of completed typing sessions
+// Entries are appended to a JSON Lines file, one entry per line, so the file
+// is never rewritten and a crash can at worst lose the entry being written.
+package history
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+	"typr2/typing"
+)
+
+// The result of a single completed prompt
+type Entry struct {
+	Time        time.Time       `json:"time"`
+	Keyboard    string          `json:"keyboard"`
+	Lesson      string          `json:"lesson,omitempty"`
+	Mode        string          `json:"mode,omitempty"` // Test mode, e.g. "time 30", empty for prompts
+	Prompt      string          `json:"prompt"`
+	WPM         float64         `json:"wpm"`
+	NetWPM      float64         `json:"net_wpm"`
+	Accuracy    float64         `json:"accuracy"`
+	Errors      int             `json:"errors"`
+	KeyErrors   map[string]int  `json:"key_errors,omitempty"`   // Mistakes per expected character
+	KeyStats    typing.KeyStats `json:"key_stats,omitempty"`    // Hits, misses and latency per expected character
+	BigramStats typing.KeyStats `json:"bigram_stats,omitempty"` // The same per pair of consecutive characters
+	Duration    time.Duration   `json:"duration"`
+}
+
+// Criteria for selecting history entries
+// Zero values match everything.
+type Filter struct {
+	From     time.Time // Inclusive
+	To       time.Time // Exclusive
+	Keyboard string
+	Lesson   string
+}
+
+// Whether the entry matches the filter
+func (f Filter) Match(e Entry) bool {
+	if !f.From.IsZero() && e.Time.Before(f.From) {
+		return false
+	}
+	if !f.To.IsZero() && !e.Time.Before(f.To) {
+		return false
+	}
+	if f.Keyboard != "" && e.Keyboard != f.Keyboard {
+		return false
+	}
+	if f.Lesson != "" && e.Lesson != f.Lesson {
+		return false
+	}
+	return true
+}
+
+// An append-only store of history entries backed by a file
+type Store struct {
+	path string
+}
+
+// Open the store at the given path, creating its directory if needed
+// The file itself is only created once the first entry is added.
+func Open(path string) (*Store, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create history directory: %w", err)
+	}
+
+	return &Store{path: path}, nil
+}
+
+// Location of the store on disk
+func (s *Store) Path() string {
+	return s.path
+}
+
+// Append an entry to the store
+func (s *Store) Add(e Entry) error {
+	line, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("failed to encode history entry: %w", err)
+	}
+
+	f, err := os.OpenFile(s.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open history: %w", err)
+	}
+	defer f.Close()
+
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write history: %w", err)
+	}
+
+	return nil
+}
+
+// Return all entries matching the filter, oldest first
+// Lines that can't be parsed (e.g. a partial write) are skipped.
+func (s *Store) Query(filter Filter) ([]Entry, error) {
+	f, err := os.Open(s.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open history: %w", err)
+	}
+	defer f.Close()
+
+	var entries []Entry
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var e Entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			continue
+		}
+		if filter.Match(e) {
+			entries = append(entries, e)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read history: %w", err)
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].Time.Before(entries[j].Time)
+	})
+
+	return entries, nil
+}
@@ -1,19 +1,24 @@
 package main
 
 import (
+	"errors"
+	"fmt"
+	"io/fs"
 	"log"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"typr2/history"
+	"typr2/typing"
 
 	tea "github.com/charmbracelet/bubbletea"
 )
 
 // Minimum terminal dimensions
+// The keyboard scales down to fit, so this only needs to leave room for the prompt
 const (
-	// MinWidth  = 80
-	// MinHeight = 24
-	
-	// Currently need 88x27 just to display 15u x 5u keyboard
-	MinWidth  = 88
-	MinHeight = 27+11
+	MinWidth  = 80
+	MinHeight = 24
 )
 
 // Screen types
@@ -24,6 +29,7 @@ const (
 	MainScreen
 	ConfigScreen
 	ExtrasScreen
+	ResultsScreen
 )
 
 // Messages
@@ -31,6 +37,17 @@ type ScreenChangeMsg struct {
 	screen Screen
 }
 
+// Sent when a key highlighted on the onscreen keyboard should be released
+type KeyReleaseMsg struct {
+	key int // Index of the key on the keyboard
+	id  int // Which press of the key this releases
+}
+
+// Sent when a completed prompt should move on to the next one
+type AdvancePromptMsg struct {
+	id int // Which prompt was completed
+}
+
 // Command mode state
 type CommandMode int
 
@@ -42,58 +59,160 @@ const (
 
 // Main application model
 type Model struct {
-	currentScreen Screen
-	termWidth     int
-	termHeight    int
-	ready         bool
-	err           error
-	menuSelection int // For navigating menu items
-	commandMode   CommandMode
-	commandInput  string
-	commandError  string
-	config        Config
-	keyboard      Keyboard
-	prompt        string
-	userInput     string
-	currentChar   int
-	prompts       []string
-	promptIndex   int
-	pressedKeys   map[string]bool
+	currentScreen  Screen
+	termWidth      int
+	termHeight     int
+	ready          bool
+	err            error
+	menuSelection  int // For navigating menu items
+	commandMode    CommandMode
+	escaped        bool // Esc was just pressed while typing, so the command and search keys aren't typed
+	commandInput   string
+	commandError   string
+	config         Config
+	configPath     string // Where the config is loaded from and saved to
+	configErr      error  // Why the config file failed to load, if it did, in which case it isn't saved over
+	keyboard       Keyboard
+	keyboardFile   string          // Absolute path of the keyboard file
+	keyboardOpts   KeyboardOptions // What the keyboard file was loaded with
+	session        *typing.Session // Typing progress for the current prompt
+	recorded       bool            // Whether the current session was saved to history
+	history        *history.Store
+	keyStats       typing.KeyStats // Per-key statistics from all sessions
+	bigramStats    typing.KeyStats // Per-bigram statistics from all sessions
+	heatmap        heatmapMode
+	lessons        []Lesson
+	lesson         int             // Index of the current lesson
+	passedLessons  map[string]bool // IDs of the lessons passed so far
+	rng            *rand.Rand      // Picks the words for drills and made up prompts
+	lessonActive   bool            // Whether the prompts come from the current lesson
+	source         PromptSource    // Where the prompts come from
+	sourceName     string          // What the source is, e.g. "Lesson: Home row"
+	sourceArgs     string          // Arguments of the source command that picked the source, unless it's a lesson
+	promptNumber   int             // Prompts started from the source so far
+	mode           TestMode        // Prompts, or a timed, word count or zen test
+	promptID       int             // Changes with every new prompt
+	pressedKeys    map[int]int     // Highlighted keys (by index) and the ID of their press
+	keyPressID     int             // ID of the last key press
+	layoutAnalysis string          // Layout comparison shown on the extras screen
 }
 
 // Initialize the application
-func InitialModel(config string) Model {
+// The keyboard layout file falls back to the one in the config if empty.
+func InitialModel(keyboardFile string, opts KeyboardOptions) Model {
 	log.Println("init.InitialModel()")
 
-	var prompts = []string{
-		"Pack my box with five dozen liquor jugs.",
-		"The quick brown fox jumps over the lazy dog.",
-		"Waltz, bad nymph, for quick jigs vex.",
-		"How vexingly quick daft zebras jump!",
-		"Bright vixens jump; dozy fowl quack.",
+	// A broken config shouldn't keep the app from starting, so fall back to
+	// the defaults and let the user know what's wrong
+	var startupErrs []error
+	var configErr error
+	config := DefaultConfig()
+	path, err := configPath()
+	if err != nil {
+		log.Printf("Config disabled: %v", err)
+	} else {
+		// User themes and layouts need to be known before the config is
+		// validated
+		if err := loadThemes(filepath.Join(filepath.Dir(path), "themes")); err != nil {
+			log.Printf("Error: %v", err)
+			startupErrs = append(startupErrs, fmt.Errorf("Failed to load themes: %w", err))
+		}
+		if err := loadLayouts(filepath.Join(filepath.Dir(path), "layouts")); err != nil {
+			log.Printf("Error: %v", err)
+			startupErrs = append(startupErrs, fmt.Errorf("Failed to load layouts: %w", err))
+		}
+
+		if loaded, err := LoadConfig(path); err == nil {
+			config = loaded
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			log.Printf("Error: %v", err)
+			startupErrs = append(startupErrs, fmt.Errorf("Using default config: %w", err))
+			configErr = err
+		}
 	}
+	ApplyTheme(themes[config.Theme])
 
-	kb, err := loadKeyboard(config)
+	// A keyboard file that moved is only a problem if it's the one to use
+	if keyboardFile == "" {
+		keyboardFile = config.KeyboardFile
+	} else if _, err := os.Stat(config.KeyboardFile); config.KeyboardFile != "" && err != nil {
+		log.Printf("Warning: %v", err)
+		startupErrs = append(startupErrs, fmt.Errorf("keyboard_file %q can't be read: %w", config.KeyboardFile, err))
+	}
+	if keyboardFile == "" {
+		log.Fatal("No keyboard layout given, and no keyboard_file set in " + path)
+	}
+
+	// Broken lesson files are skipped, the built-in lessons are always there
+	var lessonDir string
+	if path != "" {
+		lessonDir = filepath.Join(filepath.Dir(path), "lessons")
+	}
+	lessons, err := loadLessons(lessonDir)
+	if err != nil {
+		log.Printf("Error: %v", err)
+		startupErrs = append(startupErrs, fmt.Errorf("Failed to load lessons: %w", err))
+	}
+	kb, err := loadKeyboard(keyboardFile, opts)
 	if err != nil {
 		log.Fatalf("Failed to load keyboard: %v", err)
 	}
+	if kb.Warning != "" {
+		log.Printf("Warning: %s", kb.Warning)
+		startupErrs = append(startupErrs, errors.New(kb.Warning))
+	}
+	assignFingers(&kb, config.Fingers[kb.Meta.Name])
+	applyLayout(&kb, layouts[config.Layout])
 
-	return Model{
+	// History is nice to have, but not worth refusing to start over
+	store, err := openHistory()
+	if err != nil {
+		log.Printf("History disabled: %v", err)
+	}
+
+	keyStats, bigramStats, err := loadKeyStats(store)
+	if err != nil {
+		log.Printf("Failed to load key statistics: %v", err)
+	}
+
+	passedLessons, err := loadPassedLessons(store, lessons)
+	if err != nil {
+		log.Printf("Failed to load passed lessons: %v", err)
+	}
+
+	heatmap, _ := parseHeatmapMode(config.Typing.Heatmap)
+
+	// Everything that went wrong, not just the last thing
+	var startupMessage string
+	if err := errors.Join(startupErrs...); err != nil {
+		startupMessage = err.Error()
+	}
+
+	m := Model{
 		currentScreen: StartScreen,
 		ready:         false,
 		menuSelection: 0,
 		commandMode:   NormalMode,
 		commandInput:  "",
-		commandError:  "",
-		config:        DefaultConfig(),
+		commandError:  startupMessage,
+		config:        config,
+		configPath:    path,
+		configErr:     configErr,
 		keyboard:      kb,
-		prompts:       prompts,
-		promptIndex:   0,
-		prompt:        prompts[0],
-		userInput:     "",
-		currentChar:   0,
-		pressedKeys:   make(map[string]bool),
+		keyboardFile:  absPath(keyboardFile),
+		keyboardOpts:  opts,
+		lessons:       lessons,
+		passedLessons: passedLessons,
+		history:       store,
+		keyStats:      keyStats,
+		bigramStats:   bigramStats,
+		heatmap:       heatmap,
+		pressedKeys:   make(map[int]int),
+		rng:           newRand(config.Typing.DrillSeed),
 	}
+
+	// Pick the course up where it was left
+	return m.startLesson(m.resumeLesson())
 }
 
 // Init method (required by tea.Model interface)
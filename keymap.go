@@ -0,0 +1,433 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// What a physical key is, and what it types
+// Key codes follow QMK's naming (KC_A, KC_LSFT, ...) so layouts from
+// different sources can be compared.
+// See: https://docs.qmk.fm/keycodes_basic
+type KeyIdentity struct {
+	Code      string `json:"code"`            // Empty if the key couldn't be identified
+	Unshifted rune   `json:"unshifted"`       // Character typed without Shift, 0 if none
+	Shifted   rune   `json:"shifted"`         // Character typed with Shift, 0 if none
+	Layer     int    `json:"layer,omitempty"` // Layer the key switches to while held, 0 if it's not a layer key
+}
+
+// Whether the key switches layers, like Lower and Raise
+func (id KeyIdentity) IsLayerKey() bool {
+	return id.Layer > 0
+}
+
+// Whether the key types a visible character
+func (id KeyIdentity) IsPrintable() bool {
+	return id.Unshifted != 0 && unicode.IsGraphic(id.Unshifted) && !unicode.IsSpace(id.Unshifted)
+}
+
+// Whether the key is a Shift key
+func (id KeyIdentity) IsShift() bool {
+	return id.Code == "KC_LSFT" || id.Code == "KC_RSFT"
+}
+
+// Characters on a US ANSI keyboard, by key code
+var usCharacters = map[string][2]rune{
+	"KC_GRV":  {'`', '~'},
+	"KC_1":    {'1', '!'},
+	"KC_2":    {'2', '@'},
+	"KC_3":    {'3', '#'},
+	"KC_4":    {'4', '$'},
+	"KC_5":    {'5', '%'},
+	"KC_6":    {'6', '^'},
+	"KC_7":    {'7', '&'},
+	"KC_8":    {'8', '*'},
+	"KC_9":    {'9', '('},
+	"KC_0":    {'0', ')'},
+	"KC_MINS": {'-', '_'},
+	"KC_EQL":  {'=', '+'},
+	"KC_LBRC": {'[', '{'},
+	"KC_RBRC": {']', '}'},
+	"KC_BSLS": {'\\', '|'},
+	"KC_SCLN": {';', ':'},
+	"KC_QUOT": {'\'', '"'},
+	"KC_COMM": {',', '<'},
+	"KC_DOT":  {'.', '>'},
+	"KC_SLSH": {'/', '?'},
+	"KC_SPC":  {' ', ' '},
+	"KC_TAB":  {'\t', '\t'},
+	"KC_ENT":  {'\n', '\n'},
+}
+
+func init() {
+	for r := 'a'; r <= 'z'; r++ {
+		usCharacters["KC_"+string(unicode.ToUpper(r))] = [2]rune{r, unicode.ToUpper(r)}
+	}
+}
+
+// Key codes of named (non-character) keys, by their normalized legend
+var namedKeys = map[string]string{
+	"esc":         "KC_ESC",
+	"escape":      "KC_ESC",
+	"tab":         "KC_TAB",
+	"caps":        "KC_CAPS",
+	"capslock":    "KC_CAPS",
+	"shift":       "KC_LSFT",
+	"ctrl":        "KC_LCTL",
+	"control":     "KC_LCTL",
+	"alt":         "KC_LALT",
+	"option":      "KC_LALT",
+	"altgr":       "KC_RALT",
+	"win":         "KC_LGUI",
+	"cmd":         "KC_LGUI",
+	"command":     "KC_LGUI",
+	"super":       "KC_LGUI",
+	"meta":        "KC_LGUI",
+	"gui":         "KC_LGUI",
+	"menu":        "KC_APP",
+	"menü":        "KC_APP",
+	"app":         "KC_APP",
+	"enter":       "KC_ENT",
+	"return":      "KC_ENT",
+	"backspace":   "KC_BSPC",
+	"bksp":        "KC_BSPC",
+	"space":       "KC_SPC",
+	"spacebar":    "KC_SPC",
+	"␣":           "KC_SPC",
+	"delete":      "KC_DEL",
+	"del":         "KC_DEL",
+	"insert":      "KC_INS",
+	"ins":         "KC_INS",
+	"home":        "KC_HOME",
+	"end":         "KC_END",
+	"pgup":        "KC_PGUP",
+	"pageup":      "KC_PGUP",
+	"pgdn":        "KC_PGDN",
+	"pagedown":    "KC_PGDN",
+	"prtsc":       "KC_PSCR",
+	"printscreen": "KC_PSCR",
+	"scrolllock":  "KC_SCRL",
+	"pause":       "KC_PAUS",
+	"numlock":     "KC_NUM",
+	"↑":           "KC_UP",
+	"up":          "KC_UP",
+	"↓":           "KC_DOWN",
+	"down":        "KC_DOWN",
+	"←":           "KC_LEFT",
+	"left":        "KC_LEFT",
+	"→":           "KC_RGHT",
+	"right":       "KC_RGHT",
+}
+
+// Layers switched to by layer keys, by their normalized legend
+// Lower and Raise are the usual names on 40% keyboards, Fn on 60% ones.
+var layerKeys = map[string]int{
+	"fn":     1,
+	"lower":  1,
+	"raise":  2,
+	"adjust": 3,
+}
+
+// The layer key with the given legend, like "Lower" or "MO(2)"
+func identifyLayerKey(name string) (KeyIdentity, bool) {
+	layer, ok := layerKeys[name]
+	if !ok {
+		n, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(name, "mo("), ")"))
+		if err != nil || n < 1 || !strings.HasPrefix(name, "mo(") {
+			return KeyIdentity{}, false
+		}
+		layer = n
+	}
+	return KeyIdentity{Code: fmt.Sprintf("MO(%d)", layer), Layer: layer}, true
+}
+
+// Modifiers that come in pairs, by their left-hand code
+var rightHandModifiers = map[string]string{
+	"KC_LSFT": "KC_RSFT",
+	"KC_LCTL": "KC_RCTL",
+	"KC_LALT": "KC_RALT",
+	"KC_LGUI": "KC_RGUI",
+}
+
+// Work out the identity of a key from its legends
+// Character keys are recognized by their legends (top-left is the shifted
+// character and bottom-left the unshifted one), falling back to a US layout
+// for the character that isn't printed. Other keys are matched by name.
+func identifyKey(key Key) KeyIdentity {
+	var legends []string
+	for _, label := range key.Labels {
+		if label != "" && label != "␣" {
+			legends = append(legends, label)
+		}
+	}
+
+	// Blank keys are almost always the spacebar, and only it is this wide
+	if len(legends) == 0 {
+		if key.Width >= 3 || (len(key.Labels) > 0 && strings.Join(key.Labels, "") == "␣") {
+			return KeyIdentity{Code: "KC_SPC", Unshifted: ' ', Shifted: ' '}
+		}
+		return KeyIdentity{}
+	}
+
+	// Two-legend keys like "!\n1" (top-left shifted, bottom-left unshifted)
+	if len(key.Labels) > 6 && isCharLegend(key.Labels[0]) && isCharLegend(key.Labels[6]) {
+		shifted, unshifted := []rune(key.Labels[0])[0], []rune(key.Labels[6])[0]
+		id := identifyChar(unshifted)
+		if id.Code == "" {
+			id = identifyChar(shifted)
+		}
+		id.Unshifted, id.Shifted = unshifted, shifted
+		return id
+	}
+
+	// Named keys first, as some are single characters too (arrows)
+	legend := legends[0]
+	name := strings.ToLower(strings.Join(strings.Fields(legend), ""))
+	if id, ok := identifyLayerKey(name); ok {
+		return id
+	}
+	if code, ok := namedKeys[name]; ok {
+		chars := usCharacters[code]
+		return KeyIdentity{Code: code, Unshifted: chars[0], Shifted: chars[1]}
+	}
+
+	if isCharLegend(legend) {
+		return identifyChar([]rune(legend)[0])
+	}
+
+	// Function keys
+	if len(name) >= 2 && name[0] == 'f' && strings.Trim(name[1:], "0123456789") == "" {
+		return KeyIdentity{Code: "KC_F" + name[1:]}
+	}
+
+	// Whatever it says on it, nothing but the spacebar is that wide
+	if key.Width >= 4 {
+		return KeyIdentity{Code: "KC_SPC", Unshifted: ' ', Shifted: ' '}
+	}
+
+	return KeyIdentity{}
+}
+
+// Whether a legend is a single character
+func isCharLegend(legend string) bool {
+	return legend != "␣" && len([]rune(legend)) == 1
+}
+
+// Identity of the key that types r on a US layout
+// Letters outside of it still get their upper and lower case.
+func identifyChar(r rune) KeyIdentity {
+	for code, chars := range usCharacters {
+		if (chars[0] == r || chars[1] == r) && unicode.IsGraphic(r) {
+			return KeyIdentity{Code: code, Unshifted: chars[0], Shifted: chars[1]}
+		}
+	}
+
+	lower, upper := unicode.ToLower(r), unicode.ToUpper(r)
+	return KeyIdentity{Unshifted: lower, Shifted: upper}
+}
+
+// Work out the identity of every key on the keyboard
+// Paired modifiers (Shift, Ctrl, ...) are told apart by which half of the
+// keyboard they are on.
+func resolveKeyIdentities(kb *Keyboard) {
+	minX, _, maxX, _ := kb.Bounds()
+	center := (minX + maxX) / 2
+
+	for i := range kb.Keys {
+		key := &kb.Keys[i]
+		key.Identity = identifyKey(*key)
+
+		if right, ok := rightHandModifiers[key.Identity.Code]; ok && keyCenterX(*key) > center {
+			key.Identity.Code = right
+		}
+	}
+
+	resolveLayers(kb)
+	rememberPrinted(kb)
+}
+
+// Remember what every key types as loaded, to go back to when a layout is
+// applied
+func rememberPrinted(kb *Keyboard) {
+	for i := range kb.Keys {
+		kb.Keys[i].printed = kb.Keys[i].Identity
+	}
+}
+
+// Work out the layers of a keyboard with layer keys from the front legends
+// of its keys: front left for layer 1, front center for layer 2 and front
+// right for layer 3. Each layer is named after the key that switches to it.
+// Plenty of keyboards have front legends for other things, so this only
+// happens when the metadata says "layers": true.
+func resolveLayers(kb *Keyboard) {
+	kb.Layers = nil
+	if !kb.Meta.Layers {
+		return
+	}
+	for _, key := range kb.Keys {
+		layer := key.Identity.Layer
+		if layer == 0 || layer > 3 {
+			continue
+		}
+		for len(kb.Layers) <= layer {
+			kb.Layers = append(kb.Layers, fmt.Sprintf("layer %d", len(kb.Layers)))
+		}
+		kb.Layers[layer] = strings.ToLower(primaryLegend(key))
+	}
+	if kb.Layers == nil {
+		return
+	}
+	kb.Layers[0] = "base"
+
+	for i := range kb.Keys {
+		key := &kb.Keys[i]
+		key.Layers = nil
+		for layer := 1; layer < len(kb.Layers) && 8+layer < len(key.Labels); layer++ {
+			legend := key.Labels[8+layer]
+			if legend == "" {
+				continue
+			}
+			for len(key.Layers) < layer {
+				key.Layers = append(key.Layers, KeyIdentity{})
+			}
+			key.Layers[layer-1] = identifyLegend(legend)
+		}
+	}
+}
+
+// Identity of a key with a single legend, as on a layer
+// Characters are typed as they are, without Shift: "!" on a layer is just
+// that, not Shift and 1.
+func identifyLegend(legend string) KeyIdentity {
+	id := identifyKey(Key{Labels: []string{legend}, Width: 1})
+	if isCharLegend(legend) {
+		r := []rune(legend)[0]
+		if id.Unshifted != r {
+			id.Code = ""
+		}
+		id.Unshifted, id.Shifted = r, 0
+	}
+	return id
+}
+
+// What the key is on a layer
+// Keys are transparent on layers they have nothing on, so the base layer
+// shows through.
+func (k Key) IdentityOn(layer int) KeyIdentity {
+	if layer > 0 && layer <= len(k.Layers) && k.Layers[layer-1] != (KeyIdentity{}) {
+		return k.Layers[layer-1]
+	}
+	return k.Identity
+}
+
+// Keys (by index) that have to be pressed to type r, including Shift
+// Keys that type r unshifted are preferred, and nil is returned if no key
+// types r at all. Shifted characters use the Shift key of the other hand.
+// The key that types r always comes first.
+func (kb Keyboard) KeysFor(r rune) []int {
+	keys, _ := kb.keysAndLayerFor(r)
+	return keys
+}
+
+// Keys (by index) that have to be pressed to type r, and the layer r is on
+// Characters on the base layer are preferred, and those on other layers
+// need the layer key too, on the other hand if possible.
+func (kb Keyboard) keysAndLayerFor(r rune) ([]int, int) {
+	for i, key := range kb.Keys {
+		if key.Identity.Unshifted == r {
+			return []int{i}, 0
+		}
+	}
+
+	for i, key := range kb.Keys {
+		if key.Identity.Shifted == r {
+			return append([]int{i}, kb.shiftKeyFor(key)...), 0
+		}
+	}
+
+	for layer := 1; layer < len(kb.Layers); layer++ {
+		for i, key := range kb.Keys {
+			if layer > len(key.Layers) || key.Layers[layer-1] == (KeyIdentity{}) {
+				continue
+			}
+			switch id := key.Layers[layer-1]; {
+			case id.Unshifted == r:
+				return append([]int{i}, kb.layerKeyFor(layer, key)...), layer
+			case id.Shifted == r:
+				keys := append([]int{i}, kb.layerKeyFor(layer, key)...)
+				return append(keys, kb.shiftKeyFor(key)...), layer
+			}
+		}
+	}
+
+	return nil, 0
+}
+
+// The layer that has to be held to type r, 0 for the base layer (or if no
+// key types r)
+func (kb Keyboard) LayerFor(r rune) int {
+	_, layer := kb.keysAndLayerFor(r)
+	return layer
+}
+
+// The key (by index) that switches to a layer to type a key on it
+// That's one on the opposite hand, or any if there's only one.
+func (kb Keyboard) layerKeyFor(layer int, key Key) []int {
+	return kb.oppositeKey(key, func(k Key) bool { return k.Identity.Layer == layer })
+}
+
+// Keys (by index) that have to be pressed to type a grapheme cluster
+// Only a single character can be typed with a key, so anything longer
+// (like an emoji with a skin tone) has no keys.
+func (kb Keyboard) KeysForGrapheme(g string) []int {
+	r, size := utf8.DecodeRuneInString(g)
+	if size == 0 || size != len(g) {
+		return nil
+	}
+	return kb.KeysFor(r)
+}
+
+// The Shift key (by index) to use with a key
+// That's the one on the opposite hand, or any if there's only one.
+func (kb Keyboard) shiftKeyFor(key Key) []int {
+	return kb.oppositeKey(key, func(k Key) bool { return k.Identity.IsShift() })
+}
+
+// A key (by index) matching the filter, preferably on the other hand than
+// the given key, so both can be held at the same time
+func (kb Keyboard) oppositeKey(key Key, filter func(Key) bool) []int {
+	var matches []int
+	for i, k := range kb.Keys {
+		if !filter(k) {
+			continue
+		}
+		if k.Finger.Hand() != key.Finger.Hand() {
+			return []int{i}
+		}
+		matches = append(matches, i)
+	}
+
+	if len(matches) == 0 {
+		return nil
+	}
+	return matches[:1]
+}
+
+// Horizontal center of a key in key units
+func keyCenterX(key Key) float64 {
+	x, _, w, _ := key.Rect()
+	return x + w/2
+}
+
+// Index of the first key with the given code, or -1 if there's none
+func (kb Keyboard) KeyByCode(code string) int {
+	for i, key := range kb.Keys {
+		if key.Identity.Code == code {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestResolveLayersNeedsOptIn(t *testing.T) {
+	kb, err := loadKeyboard("config/test-keyboard.json", KeyboardOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if kb.Layers != nil {
+		t.Errorf("Layers = %q, want none", kb.Layers)
+	}
+	for _, key := range kb.Keys {
+		if key.Layers != nil {
+			t.Errorf("key %q has layers %v, want none", key.Labels, key.Layers)
+		}
+	}
+	for _, r := range ".cC" {
+		if layer := kb.LayerFor(r); layer != 0 {
+			t.Errorf("LayerFor(%q) = %d, want 0", r, layer)
+		}
+	}
+}
+
+func TestResolveLayers(t *testing.T) {
+	kb, err := loadKeyboard("config/planck-keyboard.json", KeyboardOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want := []string{"base", "lower", "raise"}; !slices.Equal(kb.Layers, want) {
+		t.Errorf("Layers = %q, want %q", kb.Layers, want)
+	}
+	if layer := kb.LayerFor('!'); layer != 1 {
+		t.Errorf("LayerFor('!') = %d, want 1", layer)
+	}
+	if layer := kb.LayerFor('1'); layer != 2 {
+		t.Errorf("LayerFor('1') = %d, want 2", layer)
+	}
+}
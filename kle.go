@@ -6,19 +6,23 @@ package main
 
 import (
 	// "encoding/json"
+	"flag"
 	"fmt"
+	"math"
 	"os"
 	"strings"
 
 	"github.com/yosuke-furukawa/json5/encoding/json5"
-	"maps"
 )
 
 // Represents a keyboard layout in KLE format
 // See: https://github.com/ijprest/kle-serial?tab=readme-ov-file#keyboard-objects
 type Keyboard struct {
-	Meta KeyboardMetadata `json:"meta"`
-	Keys []Key            `json:"keys"`
+	Meta   KeyboardMetadata `json:"meta"`
+	Keys   []Key            `json:"keys"`
+	Layers []string         `json:"layers,omitempty"` // Names of the layers, base first, if there are any others
+
+	Warning string `json:"-"` // What's missing from a keyboard that loaded anyway
 }
 
 // Represents a keyboard's metadata in KLE format (Name, Author, etc.)
@@ -32,6 +36,11 @@ type KeyboardMetadata struct {
 	SwitchBrand string `json:"switchBrand"`
 	SwitchMount string `json:"switchMount"`
 	SwitchType  string `json:"switchType"`
+
+	// Whether the front legends are what keys type on the layers of layer
+	// keys, rather than decoration. Not part of KLE, so it has to be added
+	// to the metadata by hand.
+	Layers bool `json:"layers"`
 }
 
 // See: https://github.com/ijprest/kle-serial?tab=readme-ov-file#keys
@@ -49,13 +58,15 @@ type Key struct {
 		]
 	*/
 
-	// TODO: we are ignoring the x,y coordinates and assuming row and col position
-	// X      float64 `json:"x"`
-	// Y      float64 `json:"y"`
+	// Position of the top-left corner in key units (u)
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
 
 	Width  float64 `json:"width"`
 	Height float64 `json:"height"`
 
+	// Secondary rectangle for non-rectangular keys (ISO Enter, stepped Caps
+	// Lock, etc.), relative to X and Y
 	X2      float64 `json:"x2"`
 	Y2      float64 `json:"y2"`
 	Width2  float64 `json:"width2"`
@@ -80,44 +91,69 @@ type Key struct {
 	Alignment int    `json:"alignment"`
 	FontSize  int    `json:"fontSize"`
 	TextColor string `json:"textColor"`
-	X         int    `json:"x_pos"` // column/position in the row
-	Y         int    `json:"y_pos"` // row number
+
+	// What the key is, worked out from its legends
+	Identity KeyIdentity   `json:"identity"`
+	Layers   []KeyIdentity `json:"layers,omitempty"` // What the key is on layers 1 and up, empty where it's the same as on the base layer
+	Finger   Finger        `json:"finger"`           // Finger that should press the key
+
+	printed KeyIdentity // What the key types going by its legends (or keycode), whatever the layout
 }
 
 // parseKLELayout parses the KLE JSON format into our Keyboard struct
+// Positions are computed the same way kle-serial does: each key starts where
+// the previous one ended, property objects adjust the cursor with x/y offsets,
+// and each new row resets x and advances y by one unit.
 func parseKLELayout(data []byte) (Keyboard, error) {
 	var rawData []any
 	if err := json5.Unmarshal(data, &rawData); err != nil {
 		return Keyboard{}, fmt.Errorf("failed to parse JSON5: %w", err)
 	}
 
+	keyboard, err := parseKLEKeys(rawData)
+	if err != nil {
+		return Keyboard{}, err
+	}
+
+	resolveKeyIdentities(&keyboard)
+	assignFingers(&keyboard, nil)
+
+	return keyboard, nil
+}
+
+// Parse KLE raw data (already decoded from JSON) into keys and metadata,
+// without working out what the keys are
+func parseKLEKeys(rawData []any) (Keyboard, error) {
 	keyboard := Keyboard{
 		Keys: []Key{},
 	}
 
-	// Parse metadata from first object
-	if len(rawData) > 0 {
-		if metaObj, ok := rawData[0].(map[string]any); ok {
-			keyboard.Meta = parseMetadata(metaObj)
-		}
+	// The "current" key holds all properties carried forward to the next key
+	current := Key{
+		Width:     1.0,
+		Height:    1.0,
+		Alignment: 4,
 	}
 
-	// Parse key rows
-	// TODO: while these can be decimals in the KLE layout, i'm not sure it makes
-	//       sense from a TUI perspective to have fractional rows or rotated keys
-	//       For now, changing to int for positional value and ignore the KLE x/y
-	currentY := 0
-	currentX := 0
-
-	for _, row := range rawData {
+	for r, row := range rawData {
+		switch v := row.(type) {
+		case map[string]any:
+			// Metadata can only be the first object in the file
+			if r != 0 {
+				return Keyboard{}, fmt.Errorf("keyboard metadata must be the first element (found at %d)", r)
+			}
+			keyboard.Meta = parseMetadata(v)
 
-		// Metadata is already parsed and inherently skipped by iterating
-		// arrays from the JSON here
-		if rowArray, ok := row.([]any); ok {
-			currentX = 0
-			keys := parseKeyRow(rowArray, currentX, currentY)
+		case []any:
+			keys, err := parseKeyRow(v, &current)
+			if err != nil {
+				return Keyboard{}, fmt.Errorf("row %d: %w", r, err)
+			}
 			keyboard.Keys = append(keyboard.Keys, keys...)
-			currentY += 1
+
+			// End of row, return to the start of the rotation cluster
+			current.Y += 1
+			current.X = current.RotationX
 		}
 	}
 
@@ -142,74 +178,133 @@ func parseMetadata(obj map[string]any) KeyboardMetadata {
 	if switchMount, ok := obj["switchMount"].(string); ok {
 		meta.SwitchMount = switchMount
 	}
+	if layers, ok := obj["layers"].(bool); ok {
+		meta.Layers = layers
+	}
 
 	return meta
 }
 
-func parseKeyRow(row []any, startX int, y int) []Key {
+// Parse a single row of keys, updating the carried-forward key properties
+// (cursor position, size, colors, etc.) in current as we go
+func parseKeyRow(row []any, current *Key) ([]Key, error) {
 	var keys []Key
-	currentX := startX
-
-	// Current key properties (carried forward)
-	var currentProps map[string]any
 
-	for _, item := range row {
+	for k, item := range row {
 		switch v := item.(type) {
 		case map[string]any:
-			// Key properties
-			currentProps = mergeProps(currentProps, v)
+			// Rotation starts a new cluster, so it only makes sense at the start of a row
+			if err := applyRotationProps(current, v, k == 0); err != nil {
+				return nil, err
+			}
 
-			// TODO: we ignore the x coordinate and assume an x position
-			// Handle X offset
-			// if x, ok := v["x"].(float64); ok {
-			// 	currentX += x
-			// }
+			// Key properties apply to the next key (and some to all that follow)
+			applyKeyProps(current, v)
 
 		case string:
-			// Key label - create key
-			key := Key{
-				X:      currentX,
-				Y:      y,
-				Width:  1.0,
-				Height: 1.0,
+			// Key label - create key from the current properties
+			key := *current
+			labels, err := parseLabels(v, key.Alignment)
+			if err != nil {
+				return nil, fmt.Errorf("key %d: %w", len(keys), err)
 			}
+			key.Labels = labels
 
-			// Apply current properties
-			if currentProps != nil {
-				applyKeyProps(&key, currentProps)
-				// Reset the properties so subsequent keys don't get the wrong props
-				currentProps = nil
+			// The secondary rectangle defaults to the same size as the key
+			if key.Width2 == 0 {
+				key.Width2 = key.Width
+			}
+			if key.Height2 == 0 {
+				key.Height2 = key.Height
 			}
-
-			key.Labels = parseLabels(v, key.Alignment)
 
 			keys = append(keys, key)
-			// TODO: we're incrementing position, not adding to coordinate
-			// currentX += key.Width
-			currentX += 1
+
+			// Set up for the next key
+			current.X += current.Width
+			current.Width = 1.0
+			current.Height = 1.0
+			current.X2 = 0
+			current.Y2 = 0
+			current.Width2 = 0
+			current.Height2 = 0
+			current.Nub = false
+			current.Stepped = false
+			current.Decal = false
 		}
 	}
 
-	return keys
+	return keys, nil
 }
 
-func mergeProps(existing, new map[string]any) map[string]any {
-	if existing == nil {
-		existing = make(map[string]any)
+// Apply the rotation properties (r, rx, ry) of a KLE property object
+// Setting rx or ry moves the cursor to the new cluster origin, and the
+// following rows will start from there as well.
+func applyRotationProps(key *Key, props map[string]any, firstInRow bool) error {
+	for _, prop := range []string{"r", "rx", "ry"} {
+		if _, ok := props[prop]; ok && !firstInRow {
+			return fmt.Errorf("rotation (%s) can only be specified on the first key in a row", prop)
+		}
+	}
+
+	if r, ok := props["r"].(float64); ok {
+		key.RotationAngle = r
 	}
 
-	maps.Copy(existing, new)
+	if rx, ok := props["rx"].(float64); ok {
+		key.RotationX = rx
+		key.X = key.RotationX
+		key.Y = key.RotationY
+	}
 
-	return existing
+	if ry, ok := props["ry"].(float64); ok {
+		key.RotationY = ry
+		key.X = key.RotationX
+		key.Y = key.RotationY
+	}
+
+	return nil
 }
 
+// Apply a KLE property object to the current key
+// See: https://github.com/ijprest/kle-serial/blob/4080386fcdcb66a391e1b4857532512f9ca4121e/index.ts#L153-L230
 func applyKeyProps(key *Key, props map[string]any) {
+	if x, ok := props["x"].(float64); ok {
+		key.X += x
+	}
+
+	if y, ok := props["y"].(float64); ok {
+		key.Y += y
+	}
+
 	if w, ok := props["w"].(float64); ok {
 		key.Width = w
+		key.Width2 = w
 	}
 
 	if h, ok := props["h"].(float64); ok {
 		key.Height = h
+		key.Height2 = h
+	}
+
+	if x2, ok := props["x2"].(float64); ok {
+		key.X2 = x2
+	}
+
+	if y2, ok := props["y2"].(float64); ok {
+		key.Y2 = y2
+	}
+
+	if w2, ok := props["w2"].(float64); ok {
+		key.Width2 = w2
+	}
+
+	if h2, ok := props["h2"].(float64); ok {
+		key.Height2 = h2
+	}
+
+	if l, ok := props["l"].(bool); ok {
+		key.Stepped = l
 	}
 
 	if c, ok := props["c"].(string); ok {
@@ -217,7 +312,8 @@ func applyKeyProps(key *Key, props map[string]any) {
 	}
 
 	if t, ok := props["t"].(string); ok {
-		key.TextColor = t
+		// Only the default text color is supported, the rest are per-label
+		key.TextColor = strings.Split(t, "\n")[0]
 	}
 
 	if f, ok := props["f"].(float64); ok {
@@ -244,17 +340,147 @@ func applyKeyProps(key *Key, props map[string]any) {
 		key.Nub = n
 	}
 
-	// etc...
+	if sm, ok := props["sm"].(string); ok {
+		key.SM = sm
+	}
+
+	if sb, ok := props["sb"].(string); ok {
+		key.SB = sb
+	}
+
+	if st, ok := props["st"].(string); ok {
+		key.ST = st
+	}
+}
+
+// The axis-aligned rectangle (in key units) a key occupies once rotated
+// A terminal can't draw a tilted keycap, so rotated keys keep their size and
+// are moved so that their center lands where the rotated center would be.
+// Keys turned closer to vertical than horizontal swap width and height.
+func (k Key) Rect() (x, y, width, height float64) {
+	x, y, width, height = k.X, k.Y, k.Width, k.Height
+	if k.RotationAngle == 0 {
+		return x, y, width, height
+	}
+
+	// Rotate the center of the key around the rotation origin (clockwise,
+	// since y grows downwards)
+	theta := k.RotationAngle * math.Pi / 180
+	cx := x + width/2 - k.RotationX
+	cy := y + height/2 - k.RotationY
+	rcx := k.RotationX + cx*math.Cos(theta) - cy*math.Sin(theta)
+	rcy := k.RotationY + cx*math.Sin(theta) + cy*math.Cos(theta)
+
+	angle := math.Mod(math.Abs(k.RotationAngle), 180)
+	if angle > 45 && angle < 135 {
+		width, height = height, width
+	}
+
+	return rcx - width/2, rcy - height/2, width, height
+}
+
+// The secondary rectangle of a key, moved along with the key by Rect()
+func (k Key) Rect2() (x, y, width, height float64) {
+	x, y, _, _ = k.Rect()
+	dx, dy := x-k.X, y-k.Y
+
+	return k.X + k.X2 + dx, k.Y + k.Y2 + dy, k.Width2, k.Height2
+}
+
+// Whether the key is a plain rectangle, i.e. has no distinct secondary shape
+func (k Key) IsRectangular() bool {
+	if k.Width2 == 0 || k.Height2 == 0 {
+		return true
+	}
+
+	return k.X2 == 0 && k.Y2 == 0 && k.Width2 == k.Width && k.Height2 == k.Height
+}
+
+// Bounding box of all keys in key units (u)
+func (kb Keyboard) Bounds() (minX, minY, maxX, maxY float64) {
+	if len(kb.Keys) == 0 {
+		return 0, 0, 0, 0
+	}
+
+	minX, minY = math.Inf(1), math.Inf(1)
+	maxX, maxY = math.Inf(-1), math.Inf(-1)
+	for _, key := range kb.Keys {
+		x, y, width, height := key.Rect()
+		minX = math.Min(minX, x)
+		minY = math.Min(minY, y)
+		maxX = math.Max(maxX, x+width)
+		maxY = math.Max(maxY, y+height)
+
+		if !key.IsRectangular() {
+			x, y, width, height = key.Rect2()
+			minX = math.Min(minX, x)
+			minY = math.Min(minY, y)
+			maxX = math.Max(maxX, x+width)
+			maxY = math.Max(maxY, y+height)
+		}
+	}
+
+	return minX, minY, maxX, maxY
+}
+
+// How to load a keyboard file
+type KeyboardOptions struct {
+	Format        string // "kle", "qmk", "via" or empty to tell by the contents
+	Keymap        string // QMK keymap.json or saved VIA/Vial keymap, looked for next to the keyboard file if empty
+	LayoutOptions []int  // Choices for the layout options of a VIA definition, the first for any not given
 }
 
-// Read and parse KLE layout JSON file
-func loadKeyboard(filename string) (Keyboard, error) {
+// Add the flags for keyboard options, the same for the command line, the
+// analyze command and set keyboard
+func (opts *KeyboardOptions) addFlags(flags *flag.FlagSet) {
+	flags.StringVar(&opts.Format, "format", "", "keyboard file `format`, kle, qmk or via (default by the contents)")
+	flags.StringVar(&opts.Keymap, "keymap", "", "QMK keymap.json or saved VIA/Vial keymap `file` (default found next to the keyboard file)")
+	flags.Func("options", "comma separated `choices` for the layout options of a VIA definition, e.g. 1,0", func(s string) (err error) {
+		opts.LayoutOptions, err = parseLayoutOptions(s)
+		return err
+	})
+}
+
+// Keyboard file formats
+var keyboardFormats = []string{"kle", "qmk", "via"}
+
+// Tell the format of a keyboard file by its contents: KLE raw data is an
+// array, QMK's info.json and keymap.json are objects, and so are VIA
+// definitions, with KLE raw data under layouts.keymap
+func keyboardFormat(data []byte) string {
+	var contents map[string]any
+	if err := json5.Unmarshal(data, &contents); err != nil {
+		return "kle"
+	}
+	if layouts, ok := contents["layouts"].(map[string]any); ok {
+		if _, ok := layouts["keymap"].([]any); ok {
+			return "via"
+		}
+	}
+	return "qmk"
+}
+
+// Read and parse a keyboard file: KLE raw data, a QMK info.json or
+// keymap.json, or a VIA definition
+func loadKeyboard(filename string, opts KeyboardOptions) (Keyboard, error) {
 	data, err := os.ReadFile(filename)
 	if err != nil {
 		return Keyboard{}, fmt.Errorf("failed to read file: %w", err)
 	}
 
-	return parseKLELayout(data)
+	format := opts.Format
+	if format == "" {
+		format = keyboardFormat(data)
+	}
+	switch format {
+	case "kle":
+		return parseKLELayout(data)
+	case "qmk":
+		return loadQMKKeyboard(filename, data, opts.Keymap)
+	case "via":
+		return loadVIAKeyboard(filename, data, opts)
+	}
+	return Keyboard{}, fmt.Errorf("unknown keyboard format %q, try one of %s", format, strings.Join(keyboardFormats, ", "))
 }
 
 // Reorder labels based on alignment flags
@@ -286,10 +512,18 @@ func reorderLabels(labels []string, alignment int) []string {
 	return retVal
 }
 
-func parseLabels(labelStr string, alignment int) []string {
-	// Split labels by newline and trim whitespace
-
+// Split a KLE legend into its 12 labels, in the order of the alignment
+// flags. Files can come from anywhere, so a legend with too many lines or an
+// unknown alignment is an error.
+func parseLabels(labelStr string, alignment int) ([]string, error) {
 	labels := strings.Split(labelStr, "\n")
+	if len(labels) > 12 {
+		return nil, fmt.Errorf("legend %q has %d lines, but keys have at most 12", labelStr, len(labels))
+	}
+	if alignment < 0 || alignment > 7 {
+		return nil, fmt.Errorf("text alignment (a) must be 0 to 7, got %d", alignment)
+	}
+
 	for i := range 12 {
 		if i >= len(labels) {
 			labels = append(labels, "") // Fill missing labels with empty strings
@@ -303,11 +537,8 @@ func parseLabels(labelStr string, alignment int) []string {
 			labels[i] = newLabel
 		}
 	}
-	if len(labels) != 12 {
-		panic(fmt.Sprintf("Expected 12 labels, got %d: %v", len(labels), labels))
-	}
 
-	return reorderLabels(labels, alignment)
+	return reorderLabels(labels, alignment), nil
 }
 
 // This should format the value of any marshalable type into a pretty-printed JSON5 string
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+// Where a key is and how big, or its secondary rectangle
+type kleRect struct{ x, y, w, h float64 }
+
+func (r kleRect) near(x, y, w, h float64) bool {
+	const e = 1e-9
+	return math.Abs(r.x-x) < e && math.Abs(r.y-y) < e && math.Abs(r.w-w) < e && math.Abs(r.h-h) < e
+}
+
+func TestParseKLEPositions(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  string
+		want []kleRect
+	}{
+		{"rows", `[["A", "B"], ["C"]]`, []kleRect{{0, 0, 1, 1}, {1, 0, 1, 1}, {0, 1, 1, 1}}},
+		{"x carries within the row", `[["A", {x: 0.5}, "B", "C"], [{x: 0.25}, "D"]]`,
+			[]kleRect{{0, 0, 1, 1}, {1.5, 0, 1, 1}, {2.5, 0, 1, 1}, {0.25, 1, 1, 1}}},
+		{"y carries to the rows after", `[["A"], [{y: 0.5}, "B"], ["C"]]`,
+			[]kleRect{{0, 0, 1, 1}, {0, 1.5, 1, 1}, {0, 2.5, 1, 1}}},
+		{"size is only for the next key", `[[{w: 2}, "A", "B"], [{h: 2}, "C", "D"]]`,
+			[]kleRect{{0, 0, 2, 1}, {2, 0, 1, 1}, {0, 1, 1, 2}, {1, 1, 1, 1}}},
+	}
+	for _, test := range tests {
+		kb, err := parseKLELayout([]byte(test.raw))
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if len(kb.Keys) != len(test.want) {
+			t.Errorf("%s: %d keys, want %d", test.name, len(kb.Keys), len(test.want))
+			continue
+		}
+		for i, key := range kb.Keys {
+			if !test.want[i].near(key.X, key.Y, key.Width, key.Height) {
+				t.Errorf("%s: key %d at %g,%g %gx%g, want %v", test.name, i, key.X, key.Y, key.Width, key.Height, test.want[i])
+			}
+		}
+	}
+
+	// Metadata has to come first
+	if _, err := parseKLELayout([]byte(`[["A"], {name: "Late"}]`)); err == nil {
+		t.Error("metadata after the first row accepted")
+	}
+	kb, err := parseKLELayout([]byte(`[{name: "Test", author: "Someone"}, ["A"]]`))
+	if err != nil || kb.Meta.Name != "Test" || kb.Meta.Author != "Someone" || len(kb.Keys) != 1 {
+		t.Errorf("metadata = %+v with %d keys (%v)", kb.Meta, len(kb.Keys), err)
+	}
+}
+
+func TestParseLabels(t *testing.T) {
+	tests := []struct {
+		legend    string
+		alignment int
+		want      map[int]string // Labels by position, the rest are empty
+	}{
+		{"A", 4, map[int]string{0: "A"}},
+		{"!\n1", 4, map[int]string{0: "!", 6: "1"}},
+		{"A\nB\nC\nD", 0, map[int]string{0: "A", 6: "B", 2: "C", 8: "D"}},
+		{"A\nB", 5, map[int]string{1: "A", 7: "B"}},
+		{"A", 7, map[int]string{4: "A"}},
+		{"Q\n\n\n\n\n\n\n\n\nFn", 4, map[int]string{0: "Q", 1: "␣", 2: "␣", 3: "␣", 4: "Fn", 5: "␣", 6: "␣", 8: "␣", 10: "␣"}},
+		{"", 4, map[int]string{0: "␣"}}, // The spacebar
+	}
+	for _, test := range tests {
+		labels, err := parseLabels(test.legend, test.alignment)
+		if err != nil {
+			t.Errorf("parseLabels(%q, %d): %v", test.legend, test.alignment, err)
+			continue
+		}
+		for i, label := range labels {
+			if label != test.want[i] {
+				t.Errorf("parseLabels(%q, %d)[%d] = %q, want %q", test.legend, test.alignment, i, label, test.want[i])
+			}
+		}
+	}
+
+	// User files can have anything in them
+	if _, err := parseLabels(strings.Repeat("x\n", 12)+"x", 4); err == nil {
+		t.Error("legend with 13 lines accepted")
+	}
+	if _, err := parseLabels("A", 8); err == nil {
+		t.Error("alignment 8 accepted")
+	}
+	if _, err := parseKLELayout([]byte(`[["A", {a: 9}, "B"]]`)); err == nil || !strings.Contains(err.Error(), "row 0: key 1:") {
+		t.Errorf("bad alignment in a file: %v", err)
+	}
+}
+
+func TestParseKLERotation(t *testing.T) {
+	kb, err := parseKLELayout([]byte(`[
+		["Esc"],
+		[{r: 15, rx: 1, ry: 2}, "A", "B"],
+		["C"],
+		[{r: -10, rx: 5, ry: 0, y: 1}, "D"],
+		[{r: 0, rx: 0, ry: 0, y: 4}, "E"]
+	]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Setting rx or ry starts the cluster, and its rows, at that point
+	want := []struct {
+		rect          kleRect
+		angle, rx, ry float64
+	}{
+		{kleRect{0, 0, 1, 1}, 0, 0, 0},
+		{kleRect{1, 2, 1, 1}, 15, 1, 2},
+		{kleRect{2, 2, 1, 1}, 15, 1, 2},
+		{kleRect{1, 3, 1, 1}, 15, 1, 2},
+		{kleRect{5, 1, 1, 1}, -10, 5, 0},
+		{kleRect{0, 4, 1, 1}, 0, 0, 0},
+	}
+	for i, key := range kb.Keys {
+		if !want[i].rect.near(key.X, key.Y, key.Width, key.Height) || key.RotationAngle != want[i].angle || key.RotationX != want[i].rx || key.RotationY != want[i].ry {
+			t.Errorf("key %d at %g,%g turned %g° around %g,%g, want %+v", i, key.X, key.Y, key.RotationAngle, key.RotationX, key.RotationY, want[i])
+		}
+	}
+
+	// A cluster can only start at the start of a row
+	if _, err := parseKLELayout([]byte(`[["A", {r: 10}, "B"]]`)); err == nil {
+		t.Error("rotation part way through a row accepted")
+	}
+}
+
+func TestKeyRect(t *testing.T) {
+	tests := []struct {
+		key  Key
+		want kleRect
+	}{
+		{Key{X: 1, Y: 2, Width: 1.5, Height: 1}, kleRect{1, 2, 1.5, 1}},
+		// A quarter turn around the top-left corner, so it's on its side
+		{Key{Width: 2, Height: 1, RotationAngle: 90}, kleRect{-1, 0, 1, 2}},
+		{Key{X: 1, Width: 1, Height: 1, RotationAngle: 180, RotationX: 1}, kleRect{0, -1, 1, 1}},
+		// A slight turn keeps the size, with the center where it turns to
+		{Key{Width: 1, Height: 1, RotationAngle: 30}, kleRect{
+			0.5*math.Cos(math.Pi/6) - 0.5*math.Sin(math.Pi/6) - 0.5,
+			0.5*math.Sin(math.Pi/6) + 0.5*math.Cos(math.Pi/6) - 0.5,
+			1, 1,
+		}},
+	}
+	for _, test := range tests {
+		if x, y, w, h := test.key.Rect(); !test.want.near(x, y, w, h) {
+			t.Errorf("Rect() of %+v = %g,%g %gx%g, want %v", test.key, x, y, w, h, test.want)
+		}
+	}
+}
+
+func TestParseKLESecondaryRect(t *testing.T) {
+	kb, err := parseKLELayout([]byte(`[
+		[{x: 0.25, w: 1.25, h: 2, w2: 1.5, h2: 1, x2: -0.25}, "Enter"],
+		[{w: 1.75, w2: 1.25, l: true}, "Caps"],
+		[{w: 2}, "Shift"],
+		["A"]
+	]`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []struct {
+		rect, rect2 kleRect
+		stepped     bool
+		rectangular bool
+	}{
+		{kleRect{0.25, 0, 1.25, 2}, kleRect{0, 0, 1.5, 1}, false, false}, // ISO Enter
+		{kleRect{0, 1, 1.75, 1}, kleRect{0, 1, 1.25, 1}, true, false},    // Stepped Caps Lock
+		{kleRect{0, 2, 2, 1}, kleRect{0, 2, 2, 1}, false, true},          // Defaults to the same rectangle
+		{kleRect{0, 3, 1, 1}, kleRect{0, 3, 1, 1}, false, true},          // Including after one that wasn't
+	}
+	for i, key := range kb.Keys {
+		x, y, w, h := key.Rect()
+		x2, y2, w2, h2 := key.Rect2()
+		if !want[i].rect.near(x, y, w, h) || !want[i].rect2.near(x2, y2, w2, h2) {
+			t.Errorf("key %d at %g,%g %gx%g and %g,%g %gx%g, want %v and %v", i, x, y, w, h, x2, y2, w2, h2, want[i].rect, want[i].rect2)
+		}
+		if key.Stepped != want[i].stepped || key.IsRectangular() != want[i].rectangular {
+			t.Errorf("key %d stepped %v, rectangular %v, want %v and %v", i, key.Stepped, key.IsRectangular(), want[i].stepped, want[i].rectangular)
+		}
+	}
+
+	// The secondary rectangle moves with the key when it's turned
+	enter := kb.Keys[0]
+	enter.RotationAngle = 90
+	x, y, _, _ := enter.Rect()
+	if x2, y2, w2, h2 := enter.Rect2(); !(kleRect{x - 0.25, y, 1.5, 1}).near(x2, y2, w2, h2) {
+		t.Errorf("turned Rect2() = %g,%g %gx%g, want %g,%g 1.5x1", x2, y2, w2, h2, x-0.25, y)
+	}
+}
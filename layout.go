@@ -0,0 +1,221 @@
+package main
+
+import (
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"unicode"
+
+	"github.com/yosuke-furukawa/json5/encoding/json5"
+)
+
+// Logical layouts that come with the application
+//
+//go:embed layouts/*.json
+var builtinLayouts embed.FS
+
+// A logical layout: what each key types, whatever its legend says
+// Layouts are made for QWERTY keyboards and follow its rows, so the first
+// character of the second row is what the key with the Q legend types:
+//
+//	{
+//	  "name": "Dvorak",
+//	  "rows": ["`1234567890[]", "',.pyfgcrl/=\\", "aoeuidhtns-", ";qjkxbmwvz"],
+//	  "shifted_rows": ["~!@#$%^&*(){}", "\"<>PYFGCRL?+|", "AOEUIDHTNS_", ":QJKXBMWVZ"]
+//	}
+//
+// Rows can be shorter to leave the keys at the end alone. Without
+// shifted_rows, letters shift to upper case and anything else to what it
+// shifts to on a US layout.
+type Layout struct {
+	ID          string   `json:"-"` // The file name
+	Name        string   `json:"name"`
+	Rows        []string `json:"rows"`
+	ShiftedRows []string `json:"shifted_rows"`
+
+	chars map[string][2]rune // Unshifted and shifted character by key code
+}
+
+// Key codes of the QWERTY rows layouts are written in
+var layoutRowCodes = [][]string{
+	{"KC_GRV", "KC_1", "KC_2", "KC_3", "KC_4", "KC_5", "KC_6", "KC_7", "KC_8", "KC_9", "KC_0", "KC_MINS", "KC_EQL"},
+	{"KC_Q", "KC_W", "KC_E", "KC_R", "KC_T", "KC_Y", "KC_U", "KC_I", "KC_O", "KC_P", "KC_LBRC", "KC_RBRC", "KC_BSLS"},
+	{"KC_A", "KC_S", "KC_D", "KC_F", "KC_G", "KC_H", "KC_J", "KC_K", "KC_L", "KC_SCLN", "KC_QUOT"},
+	{"KC_Z", "KC_X", "KC_C", "KC_V", "KC_B", "KC_N", "KC_M", "KC_COMM", "KC_DOT", "KC_SLSH"},
+}
+
+// All layouts that can be selected, by ID
+var layouts = make(map[string]Layout)
+
+func init() {
+	if err := loadLayoutFS(builtinLayouts, "layouts/*.json"); err != nil {
+		panic(err)
+	}
+}
+
+// IDs of all available layouts, sorted
+func layoutNames() []string {
+	var names []string
+	for name := range layouts {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// Parse a layout file, filling in the key map
+func parseLayout(data []byte, id string) (Layout, error) {
+	layout := Layout{ID: id, Name: id}
+	if err := json5.Unmarshal(data, &layout); err != nil {
+		return layout, err
+	}
+
+	var errs []error
+	if len(layout.Rows) == 0 || len(layout.Rows) > len(layoutRowCodes) {
+		errs = append(errs, fmt.Errorf("rows must have 1 to %d rows, got %d", len(layoutRowCodes), len(layout.Rows)))
+	}
+	if layout.ShiftedRows != nil && len(layout.ShiftedRows) != len(layout.Rows) {
+		errs = append(errs, fmt.Errorf("shifted_rows must have as many rows as rows, got %d and %d", len(layout.ShiftedRows), len(layout.Rows)))
+	}
+
+	layout.chars = make(map[string][2]rune)
+	for r, row := range layout.Rows[:min(len(layout.Rows), len(layoutRowCodes))] {
+		chars := []rune(row)
+		if len(chars) > len(layoutRowCodes[r]) {
+			errs = append(errs, fmt.Errorf("rows[%d] can have at most %d keys, got %d", r, len(layoutRowCodes[r]), len(chars)))
+			continue
+		}
+
+		var shifted []rune
+		if layout.ShiftedRows != nil && r < len(layout.ShiftedRows) {
+			shifted = []rune(layout.ShiftedRows[r])
+			if len(shifted) != len(chars) {
+				errs = append(errs, fmt.Errorf("shifted_rows[%d] must have as many keys as rows[%d], got %d and %d", r, r, len(shifted), len(chars)))
+				continue
+			}
+		}
+
+		for i, char := range chars {
+			shift := shiftedChar(char)
+			if shifted != nil {
+				shift = shifted[i]
+			}
+			layout.chars[layoutRowCodes[r][i]] = [2]rune{char, shift}
+		}
+	}
+
+	return layout, errors.Join(errs...)
+}
+
+// What a character shifts to, going by a US layout for anything but letters
+func shiftedChar(r rune) rune {
+	if id := identifyChar(r); id.Unshifted == r {
+		return id.Shifted
+	}
+	return unicode.ToUpper(r)
+}
+
+// Load the layouts matching pattern in fsys into the layouts map
+func loadLayoutFS(fsys fs.FS, pattern string) error {
+	files, err := fs.Glob(fsys, pattern)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, file := range files {
+		data, err := fs.ReadFile(fsys, file)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to read layout: %w", err))
+			continue
+		}
+
+		id := strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+		layout, err := parseLayout(data, id)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("layout %s: %w", file, err))
+			continue
+		}
+		layouts[id] = layout
+	}
+
+	return errors.Join(errs...)
+}
+
+// Load user-defined layouts from JSON files in dir
+// User layouts replace built-in ones with the same name. A missing directory
+// isn't an error.
+func loadLayouts(dir string) error {
+	if _, err := os.Stat(dir); errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return loadLayoutFS(os.DirFS(dir), "*.json")
+}
+
+// Make the keys of a keyboard type what the layout says
+// Keys the layout doesn't cover (and every key without a layout) go back to
+// what they typed as loaded.
+func applyLayout(kb *Keyboard, layout Layout) {
+	for i := range kb.Keys {
+		key := &kb.Keys[i]
+
+		key.Identity.Unshifted, key.Identity.Shifted = key.printed.Unshifted, key.printed.Shifted
+		if chars, ok := layout.chars[key.Identity.Code]; ok {
+			key.Identity.Unshifted, key.Identity.Shifted = chars[0], chars[1]
+		}
+	}
+}
+
+// Whether the layout changes what a key types
+func (l Layout) remaps(key Key) bool {
+	_, ok := l.chars[key.Identity.Code]
+	return ok
+}
+
+// A key as shown on the onscreen keyboard with a layer active: with the
+// legend it has on that layer, or the legends of the logical layout instead
+// of the printed ones if so configured
+func (m Model) displayKey(key Key, layer int) Key {
+	switch {
+	case layer > 0 && key.IdentityOn(layer) != key.Identity && 8+layer < len(key.Labels):
+		key.Labels = []string{key.Labels[8+layer]}
+	case m.config.Legends == "layout" && layouts[m.config.Layout].remaps(key):
+		key.Labels = layoutLabels(key)
+	}
+	return key
+}
+
+// Legends for a key as the layout has it, in the same places as the
+// printed ones: letters get just the upper case, other keys the shifted
+// character above the unshifted one
+func layoutLabels(key Key) []string {
+	id := key.Identity
+	labels := make([]string, 12)
+	if unicode.IsLetter(id.Unshifted) && id.Shifted == unicode.ToUpper(id.Unshifted) {
+		labels[0] = string(id.Shifted)
+	} else {
+		labels[0], labels[6] = string(id.Shifted), string(id.Unshifted)
+	}
+	return labels
+}
+
+// Translate characters as typed on QWERTY into the ones on the same keys
+// with the layout, e.g. "asdf" is "aoeu" on Dvorak
+func (l Layout) translate(text string) string {
+	return strings.Map(func(r rune) rune {
+		id := identifyChar(r)
+		chars, ok := l.chars[id.Code]
+		switch {
+		case !ok:
+			return r
+		case r == id.Shifted && r != id.Unshifted:
+			return chars[1]
+		}
+		return chars[0]
+	}, text)
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"cmp"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"unicode"
+
+	"github.com/yosuke-furukawa/json5/encoding/json5"
+)
+
+// Lessons that come with the application
+//
+//go:embed lessons/*.json
+var builtinLessons embed.FS
+
+// A lesson, loaded from a JSON (or JSON5) file
+//
+//	{
+//	  "title": "Home row",
+//	  "description": "The keys your fingers rest on.",
+//	  "order": 1,
+//	  "course": true,
+//	  "keys": "asdfjkl;",
+//	  "positional": true,
+//	  "generate": {"count": 5, "words": 8},
+//	  "pass": {"wpm": 20, "accuracy": 95}
+//	}
+//
+// Prompts are either listed in "prompts", made up from the target keys as
+// set in "generate", or drilled from the user's weakest keys with "drill".
+// Positional keys are where they are on QWERTY, and typed as whatever the
+// logical layout puts there: "asdf" is "arst" on Colemak.
+type Lesson struct {
+	ID          string          `json:"id"` // Defaults to the file name
+	Title       string          `json:"title"`
+	Description string          `json:"description"`
+	Order       int             `json:"order"`      // Lessons are sorted by this, then by ID
+	Course      bool            `json:"course"`     // Part of the course, unlocked in order
+	Keys        string          `json:"keys"`       // Keys the lesson practices
+	Positional  bool            `json:"positional"` // Keys are QWERTY positions, see above
+	Prompts     []string        `json:"prompts"`
+	Generate    *LessonGenerate `json:"generate"`
+	Drill       *LessonDrill    `json:"drill"`
+	Pass        PassCriteria    `json:"pass"`
+}
+
+// Settings for making up prompts from a lesson's keys
+type LessonGenerate struct {
+	Count     int    `json:"count"`      // Number of prompts
+	Words     int    `json:"words"`      // Words per prompt
+	MinLength int    `json:"min_length"` // Shortest word (default 2)
+	MaxLength int    `json:"max_length"` // Longest word (default 5)
+	Seed      uint64 `json:"seed"`       // Same seed, same prompts (0 for random)
+}
+
+// What it takes to pass a lesson
+type PassCriteria struct {
+	WPM      float64 `json:"wpm"`      // Minimum net WPM
+	Accuracy float64 `json:"accuracy"` // Minimum accuracy in percent
+}
+
+// Whether a session with the given results passes
+func (p PassCriteria) Passed(netWPM, accuracy float64) bool {
+	return netWPM >= p.WPM && accuracy >= p.Accuracy
+}
+
+// Check a lesson for missing or invalid values, reporting all of them
+func (l Lesson) Validate() error {
+	var errs []error
+
+	if l.ID == "" {
+		errs = append(errs, errors.New("id can't be empty"))
+	}
+	if strings.TrimSpace(l.Title) == "" {
+		errs = append(errs, errors.New("title can't be empty"))
+	}
+
+	sources := 0
+	for _, set := range []bool{len(l.Prompts) > 0, l.Generate != nil, l.Drill != nil} {
+		if set {
+			sources++
+		}
+	}
+	switch {
+	case sources == 0:
+		errs = append(errs, errors.New("needs one of prompts, generate or drill"))
+	case sources > 1:
+		errs = append(errs, errors.New("can only have one of prompts, generate or drill"))
+	}
+
+	for i, prompt := range l.Prompts {
+		if strings.TrimSpace(prompt) == "" {
+			errs = append(errs, fmt.Errorf("prompts[%d] can't be empty", i))
+		}
+	}
+
+	if g := l.Generate; g != nil {
+		if len(l.generatorRunes()) == 0 {
+			errs = append(errs, errors.New("generate needs keys to make words from"))
+		}
+		if g.Count <= 0 {
+			errs = append(errs, fmt.Errorf("generate.count must be positive, got %d", g.Count))
+		}
+		if g.Words <= 0 {
+			errs = append(errs, fmt.Errorf("generate.words must be positive, got %d", g.Words))
+		}
+		if g.MinLength < 0 || g.MaxLength < 0 {
+			errs = append(errs, fmt.Errorf("generate.min_length and max_length can't be negative, got %d and %d", g.MinLength, g.MaxLength))
+		} else if g.MaxLength > 0 && g.MinLength > g.MaxLength {
+			errs = append(errs, fmt.Errorf("generate.min_length can't be more than max_length, got %d and %d", g.MinLength, g.MaxLength))
+		}
+	}
+
+	if d := l.Drill; d != nil && d.Words <= 0 {
+		errs = append(errs, fmt.Errorf("drill.words must be positive, got %d", d.Words))
+	}
+
+	if l.Pass.WPM < 0 {
+		errs = append(errs, fmt.Errorf("pass.wpm can't be negative, got %g", l.Pass.WPM))
+	}
+	if l.Pass.Accuracy < 0 || l.Pass.Accuracy > 100 {
+		errs = append(errs, fmt.Errorf("pass.accuracy must be between 0 and 100, got %g", l.Pass.Accuracy))
+	}
+
+	return errors.Join(errs...)
+}
+
+// The characters words are made up from
+func (l Lesson) generatorRunes() []rune {
+	var runes []rune
+	for _, r := range l.Keys {
+		if !unicode.IsSpace(r) && !slices.Contains(runes, r) {
+			runes = append(runes, r)
+		}
+	}
+	return runes
+}
+
+// The prompts of a lesson, made up if the lesson has a generator
+// Drills depend on the user's statistics, see drillSource.
+func (l Lesson) PromptList() []string {
+	if l.Generate == nil {
+		return l.Prompts
+	}
+
+	g := *l.Generate
+	if g.MinLength == 0 {
+		g.MinLength = 2
+	}
+	if g.MaxLength == 0 {
+		g.MaxLength = max(5, g.MinLength)
+	}
+
+	source := randomWordSource{
+		chars:     l.generatorRunes(),
+		words:     g.Words,
+		minLength: g.MinLength,
+		maxLength: g.MaxLength,
+		rng:       newRand(g.Seed),
+	}
+	prompts := make([]string, g.Count)
+	for i := range prompts {
+		prompts[i] = source.Next()
+	}
+
+	return prompts
+}
+
+// Parse a lesson file, using id as the lesson's ID unless it sets one
+func parseLesson(data []byte, id string) (Lesson, error) {
+	lesson := Lesson{ID: id}
+	if err := json5.Unmarshal(data, &lesson); err != nil {
+		return lesson, err
+	}
+	return lesson, lesson.Validate()
+}
+
+// Load the built-in lessons and user lessons from JSON files in dir
+// User lessons replace built-in ones with the same ID. Invalid files are
+// skipped and reported together in the error, along with the lessons that
+// did load. A missing directory isn't an error.
+func loadLessons(dir string) ([]Lesson, error) {
+	byID := make(map[string]Lesson)
+	var errs []error
+
+	load := func(fsys fs.FS, dir, pattern string) {
+		files, err := fs.Glob(fsys, pattern)
+		if err != nil {
+			errs = append(errs, err)
+			return
+		}
+
+		for _, file := range files {
+			data, err := fs.ReadFile(fsys, file)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("failed to read lesson: %w", err))
+				continue
+			}
+
+			id := strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+			lesson, err := parseLesson(data, id)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("invalid lesson %s: %w", filepath.Join(dir, file), err))
+				continue
+			}
+
+			byID[lesson.ID] = lesson
+		}
+	}
+
+	load(builtinLessons, "", "lessons/*.json")
+	if dir != "" {
+		load(os.DirFS(dir), dir, "*.json")
+	}
+
+	lessons := make([]Lesson, 0, len(byID))
+	for _, lesson := range byID {
+		lessons = append(lessons, lesson)
+	}
+	slices.SortFunc(lessons, func(a, b Lesson) int {
+		return cmp.Or(cmp.Compare(a.Order, b.Order), cmp.Compare(a.ID, b.ID))
+	})
+
+	return lessons, errors.Join(errs...)
+}
+
+// Index of the lesson with the given ID, or -1 if there's none
+func findLesson(lessons []Lesson, id string) int {
+	return slices.IndexFunc(lessons, func(l Lesson) bool { return l.ID == id })
+}
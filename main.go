@@ -1,6 +1,7 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"log"
 	"os"
@@ -19,11 +20,23 @@ func main() {
 		defer f.Close()
 	}
 
-	if len(os.Args) < 2 {
-		log.Fatal("Usage: go run main.go <keyboard-layout.json>")
+	if len(os.Args) >= 2 && os.Args[1] == "analyze" {
+		if err := runAnalyze(os.Args[2:]); err != nil {
+			fmt.Println("fatal:", err)
+			os.Exit(1)
+		}
+		return
 	}
 
-	p := tea.NewProgram(InitialModel(os.Args[1]), tea.WithAltScreen())
+	// typr2 [-format kle|qmk|via] [-keymap file] [-options 1,0] [keyboard file]
+	var opts KeyboardOptions
+	opts.addFlags(flag.CommandLine)
+	flag.Parse()
+
+	// The keyboard layout can also come from the config file
+	keyboardFile := flag.Arg(0)
+
+	p := tea.NewProgram(InitialModel(keyboardFile, opts), tea.WithAltScreen())
 
 	if _, err := p.Run(); err != nil {
 		fmt.Printf("Error running program: %v", err)
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"typr2/typing"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// How a typing test is set up, and when it ends
+type modeKind int
+
+const (
+	modePrompt modeKind = iota // One prompt from the source at a time
+	modeTime                   // As many words as possible before the time runs out
+	modeWords                  // A fixed number of words
+	modeZen                    // No target text, typing until Enter
+)
+
+// The current test mode
+type TestMode struct {
+	Kind   modeKind
+	Length int // Seconds for timed tests, words for word count tests
+}
+
+// Lengths to pick from, as on Monkeytype
+var (
+	timedLengths = []int{15, 30, 60, 120}
+	wordLengths  = []int{10, 25, 50, 100}
+)
+
+// Timed tests keep at least this many characters ahead of the cursor
+const streamAhead = 80
+
+func (t TestMode) String() string {
+	switch t.Kind {
+	case modeTime:
+		return fmt.Sprintf("time %d", t.Length)
+	case modeWords:
+		return fmt.Sprintf("words %d", t.Length)
+	case modeZen:
+		return "zen"
+	}
+	return "prompt"
+}
+
+// Parse a test mode like "time 30", "words 25", "zen" or "prompt"
+// Timed and word count tests default to 30 seconds and 25 words.
+func parseTestMode(arg string) (TestMode, bool) {
+	kind, length, _ := strings.Cut(strings.TrimSpace(arg), " ")
+	length = strings.TrimSpace(length)
+
+	lengthIn := func(lengths []int, fallback int) (int, bool) {
+		if length == "" {
+			return fallback, true
+		}
+		n, err := strconv.Atoi(strings.TrimSuffix(length, "s"))
+		return n, err == nil && slices.Contains(lengths, n)
+	}
+
+	switch kind {
+	case "prompt", "off":
+		return TestMode{Kind: modePrompt}, length == ""
+	case "time":
+		n, ok := lengthIn(timedLengths, 30)
+		return TestMode{Kind: modeTime, Length: n}, ok
+	case "words":
+		n, ok := lengthIn(wordLengths, 25)
+		return TestMode{Kind: modeWords, Length: n}, ok
+	case "zen":
+		return TestMode{Kind: modeZen}, length == ""
+	}
+	return TestMode{}, false
+}
+
+// Sent every second during a timed test, to update the clock and end the
+// test when the time is up
+type TestTimerMsg struct {
+	id int // Which prompt the timer belongs to
+}
+
+// A fresh typing session for the current test mode, with the configured
+// error mode
+func (m Model) newSession() *typing.Session {
+	var session *typing.Session
+	switch m.mode.Kind {
+	case modeTime:
+		session = typing.NewSession(m.source.Next())
+	case modeWords:
+		session = typing.NewSession(m.takeWords(m.mode.Length))
+	case modeZen:
+		session = typing.NewFreeSession()
+	default:
+		session = typing.NewSession(m.source.Next())
+	}
+
+	errorMode, _ := typing.ParseErrorMode(m.config.Typing.ErrorMode)
+	session.SetErrorMode(errorMode)
+	if m.mode.Kind == modeTime {
+		m.streamWords(session)
+	}
+	return session
+}
+
+// Keep a timed test's target going with more prompts from the source
+func (m Model) streamWords(session *typing.Session) {
+	for {
+		snapshot := session.Snapshot()
+		if len(snapshot.Target)-snapshot.Cursor >= streamAhead {
+			return
+		}
+		session.Append(" " + m.source.Next())
+	}
+}
+
+// The first n words from the source, however many prompts that takes
+func (m Model) takeWords(n int) string {
+	var words []string
+	for len(words) < n {
+		words = append(words, strings.Fields(m.source.Next())...)
+	}
+	return strings.Join(words[:n], " ")
+}
+
+// Time left in a timed test
+// The clock starts with the first keystroke.
+func (m Model) timeLeft(snapshot typing.Snapshot, now time.Time) time.Duration {
+	limit := time.Duration(m.mode.Length) * time.Second
+	if snapshot.Start.IsZero() {
+		return limit
+	}
+	return max(limit-now.Sub(snapshot.Start), 0)
+}
+
+// Schedule the next tick of a timed test's clock, on the next whole second
+func (m Model) tickTestTimer(snapshot typing.Snapshot) tea.Cmd {
+	left := m.timeLeft(snapshot, time.Now())
+	delay := left % time.Second
+	if delay == 0 {
+		delay = min(left, time.Second)
+	}
+
+	id := m.promptID
+	return tea.Tick(delay, func(time.Time) tea.Msg {
+		return TestTimerMsg{id: id}
+	})
+}
+
+// Handle a tick of a timed test's clock, ending the test when time is up
+func (m Model) handleTestTimer(msg TestTimerMsg) (Model, tea.Cmd) {
+	if msg.id != m.promptID || m.mode.Kind != modeTime || m.session.Done() {
+		return m, nil
+	}
+
+	if !m.timeUp(time.Now()) {
+		return m, m.tickTestTimer(m.session.Snapshot())
+	}
+	return m.endTimedTest()
+}
+
+// Whether a timed test has run out of time, whether or not the clock has
+// ticked since
+func (m Model) timeUp(now time.Time) bool {
+	snapshot := m.session.Snapshot()
+	return m.mode.Kind == modeTime && !m.session.Done() && !snapshot.Start.IsZero() && m.timeLeft(snapshot, now) == 0
+}
+
+// End a timed test that's out of time
+func (m Model) endTimedTest() (Model, tea.Cmd) {
+	// Exactly on time, however late the tick or keystroke came
+	snapshot := m.session.Snapshot()
+	m.session.Finish(snapshot.Start.Add(time.Duration(m.mode.Length) * time.Second))
+	return m.completeSession()
+}
+
+// Words typed so far, counting the one being typed
+func typedWords(snapshot typing.Snapshot) int {
+	return len(strings.Fields(strings.Join(snapshot.Target[:snapshot.Cursor], "")))
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// A model in a test mode, with the same prompt over and over
+func newTestModeModel(mode TestMode) Model {
+	m := newTestModel()
+	m.mode = mode
+	return m.startSource(&listSource{prompts: []string{"the quick brown fox"}}, "Test")
+}
+
+func TestTimeMode(t *testing.T) {
+	m := newTestModeModel(TestMode{Kind: modeTime, Length: 15})
+	if target := m.session.Target(); len(target) < streamAhead {
+		t.Errorf("target %q is shorter than %d", target, streamAhead)
+	}
+
+	// In time, keystrokes count and more words come
+	m = typeKeys(m, strings.Repeat("the quick brown fox ", 4))
+	if snapshot := m.session.Snapshot(); m.session.Done() || len(snapshot.Target)-snapshot.Cursor < streamAhead {
+		t.Fatalf("done %v with %d left to type, want more to type", m.session.Done(), len(snapshot.Target)-snapshot.Cursor)
+	}
+
+	// After the time is up they don't, even before the clock ticks
+	m = newTestModeModel(TestMode{Kind: modeTime, Length: 15})
+	start := time.Now().Add(-20 * time.Second)
+	m.session.Type("t", start)
+	m = typeKeys(m, "he")
+	snapshot := m.session.Snapshot()
+	if !snapshot.Done || !m.recorded || snapshot.Cursor != 1 {
+		t.Errorf("done %v, recorded %v with %d typed, want the test over with 1 typed", snapshot.Done, m.recorded, snapshot.Cursor)
+	}
+	if want := start.Add(15 * time.Second); !snapshot.End.Equal(want) {
+		t.Errorf("ended %v after the start, want 15s", snapshot.End.Sub(start))
+	}
+
+	// Nor does Backspace
+	m = newTestModeModel(TestMode{Kind: modeTime, Length: 15})
+	m.session.Type("th", start)
+	next, _ := m.Update(tea.KeyMsg{Type: tea.KeyBackspace})
+	if m = next.(Model); !m.session.Done() || m.session.Snapshot().Cursor != 2 {
+		t.Errorf("done %v with %d typed after Backspace, want the test over with 2 typed", m.session.Done(), m.session.Snapshot().Cursor)
+	}
+
+	// A late tick ends the test on time too
+	m = newTestModeModel(TestMode{Kind: modeTime, Length: 15})
+	m.session.Type("t", start)
+	m, _ = m.handleTestTimer(TestTimerMsg{id: m.promptID})
+	if snapshot := m.session.Snapshot(); !snapshot.Done || !snapshot.End.Equal(start.Add(15*time.Second)) {
+		t.Errorf("done %v after %v, want the test over after 15s", snapshot.Done, snapshot.End.Sub(start))
+	}
+}
+
+func TestWordsMode(t *testing.T) {
+	m := newTestModeModel(TestMode{Kind: modeWords, Length: 10})
+	want := "the quick brown fox the quick brown fox the quick"
+	if target := m.session.Target(); target != want {
+		t.Fatalf("target = %q, want %q", target, want)
+	}
+
+	m = typeKeys(m, want[:len(want)-1])
+	if m.session.Done() {
+		t.Error("done before the last character")
+	}
+	m = typeKeys(m, want[len(want)-1:])
+	if !m.session.Done() || !m.recorded {
+		t.Errorf("done %v, recorded %v at the end, want both", m.session.Done(), m.recorded)
+	}
+}
+
+func TestZenMode(t *testing.T) {
+	m := newTestModeModel(TestMode{Kind: modeZen})
+	enter := func(m Model) Model {
+		next, _ := m.Update(tea.KeyMsg{Type: tea.KeyEnter})
+		return next.(Model)
+	}
+
+	// Nothing typed, nothing to end
+	if m = enter(m); m.session.Done() {
+		t.Error("Enter ended a test with nothing typed")
+	}
+
+	m = typeKeys(m, strings.Repeat("anything at all ", 10))
+	if m.session.Done() {
+		t.Error("zen test ended by itself")
+	}
+	if m = enter(m); !m.session.Done() || !m.recorded {
+		t.Errorf("done %v, recorded %v after Enter, want both", m.session.Done(), m.recorded)
+	}
+	if typed := strings.Join(m.session.Snapshot().Input, ""); typed != strings.Repeat("anything at all ", 10) {
+		t.Errorf("typed %q", typed)
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+const appName = "typr2"
+
+// Directory for application data such as typing history
+// Follows the XDG base directory spec: $XDG_DATA_HOME/typr2, falling back to
+// ~/.local/share/typr2
+func dataDir() (string, error) {
+	if dir := os.Getenv("XDG_DATA_HOME"); filepath.IsAbs(dir) {
+		return filepath.Join(dir, appName), nil
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to find data directory: %w", err)
+	}
+
+	return filepath.Join(home, ".local", "share", appName), nil
+}
+
+// Location of the configuration file
+// os.UserConfigDir already follows $XDG_CONFIG_HOME, defaulting to ~/.config
+func configPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to find config directory: %w", err)
+	}
+
+	return filepath.Join(dir, appName, "config.json"), nil
+}
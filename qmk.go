@@ -0,0 +1,495 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/yosuke-furukawa/json5/encoding/json5"
+)
+
+// A QMK info.json (or keyboard.json): the physical layouts of a keyboard
+// See: https://docs.qmk.fm/reference_info_json
+type qmkInfo struct {
+	KeyboardName  string               `json:"keyboard_name"`
+	Manufacturer  string               `json:"manufacturer"`
+	Layouts       map[string]qmkLayout `json:"layouts"`
+	LayoutAliases map[string]string    `json:"layout_aliases"`
+}
+
+type qmkLayout struct {
+	Layout []qmkKey `json:"layout"`
+}
+
+// A key of a QMK layout, in key units like KLE
+// Rotated keys turn by R degrees around RX, RY.
+type qmkKey struct {
+	Label  string  `json:"label"`
+	Matrix []int   `json:"matrix"`
+	X      float64 `json:"x"`
+	Y      float64 `json:"y"`
+	W      float64 `json:"w"`
+	H      float64 `json:"h"`
+	R      float64 `json:"r"`
+	RX     float64 `json:"rx"`
+	RY     float64 `json:"ry"`
+}
+
+// A QMK keymap.json: keycodes for every key of a layout, layer by layer
+// See: https://docs.qmk.fm/configurator_default_keymaps
+type qmkKeymap struct {
+	Keyboard string     `json:"keyboard"`
+	Keymap   string     `json:"keymap"`
+	Layout   string     `json:"layout"`
+	Layers   [][]string `json:"layers"`
+}
+
+// Long keycode names, by the short ones the rest of the app uses
+var qmkAliases = map[string]string{
+	"KC_ENTER":               "KC_ENT",
+	"KC_ESCAPE":              "KC_ESC",
+	"KC_BACKSPACE":           "KC_BSPC",
+	"KC_SPACE":               "KC_SPC",
+	"KC_MINUS":               "KC_MINS",
+	"KC_EQUAL":               "KC_EQL",
+	"KC_LEFT_BRACKET":        "KC_LBRC",
+	"KC_RIGHT_BRACKET":       "KC_RBRC",
+	"KC_BACKSLASH":           "KC_BSLS",
+	"KC_SEMICOLON":           "KC_SCLN",
+	"KC_QUOTE":               "KC_QUOT",
+	"KC_GRAVE":               "KC_GRV",
+	"KC_COMMA":               "KC_COMM",
+	"KC_SLASH":               "KC_SLSH",
+	"KC_CAPS_LOCK":           "KC_CAPS",
+	"KC_LEFT_SHIFT":          "KC_LSFT",
+	"KC_RIGHT_SHIFT":         "KC_RSFT",
+	"KC_LEFT_CTRL":           "KC_LCTL",
+	"KC_RIGHT_CTRL":          "KC_RCTL",
+	"KC_LEFT_ALT":            "KC_LALT",
+	"KC_RIGHT_ALT":           "KC_RALT",
+	"KC_LOPT":                "KC_LALT",
+	"KC_ROPT":                "KC_RALT",
+	"KC_ALGR":                "KC_RALT",
+	"KC_LEFT_GUI":            "KC_LGUI",
+	"KC_RIGHT_GUI":           "KC_RGUI",
+	"KC_LCMD":                "KC_LGUI",
+	"KC_RCMD":                "KC_RGUI",
+	"KC_LWIN":                "KC_LGUI",
+	"KC_RWIN":                "KC_RGUI",
+	"KC_DELETE":              "KC_DEL",
+	"KC_INSERT":              "KC_INS",
+	"KC_PAGE_UP":             "KC_PGUP",
+	"KC_PAGE_DOWN":           "KC_PGDN",
+	"KC_RIGHT":               "KC_RGHT",
+	"KC_PRINT_SCREEN":        "KC_PSCR",
+	"KC_SCROLL_LOCK":         "KC_SCRL",
+	"KC_PAUSE":               "KC_PAUS",
+	"KC_NUM_LOCK":            "KC_NUM",
+	"KC_APPLICATION":         "KC_APP",
+	"KC_TILDE":               "KC_TILD",
+	"KC_EXCLAIM":             "KC_EXLM",
+	"KC_DOLLAR":              "KC_DLR",
+	"KC_PERCENT":             "KC_PERC",
+	"KC_CIRCUMFLEX":          "KC_CIRC",
+	"KC_AMPERSAND":           "KC_AMPR",
+	"KC_ASTERISK":            "KC_ASTR",
+	"KC_LEFT_PAREN":          "KC_LPRN",
+	"KC_RIGHT_PAREN":         "KC_RPRN",
+	"KC_UNDERSCORE":          "KC_UNDS",
+	"KC_LEFT_CURLY_BRACE":    "KC_LCBR",
+	"KC_RIGHT_CURLY_BRACE":   "KC_RCBR",
+	"KC_COLON":               "KC_COLN",
+	"KC_DOUBLE_QUOTE":        "KC_DQUO",
+	"KC_DQT":                 "KC_DQUO",
+	"KC_LEFT_ANGLE_BRACKET":  "KC_LABK",
+	"KC_LT":                  "KC_LABK",
+	"KC_RIGHT_ANGLE_BRACKET": "KC_RABK",
+	"KC_GT":                  "KC_RABK",
+	"KC_QUESTION":            "KC_QUES",
+	"KC_TRANSPARENT":         "KC_TRNS",
+	"_______":                "KC_TRNS",
+	"XXXXXXX":                "KC_NO",
+}
+
+// Keycodes for characters typed with Shift, by the key they shift
+var qmkShifted = map[string]string{
+	"KC_TILD": "KC_GRV",
+	"KC_EXLM": "KC_1",
+	"KC_AT":   "KC_2",
+	"KC_HASH": "KC_3",
+	"KC_DLR":  "KC_4",
+	"KC_PERC": "KC_5",
+	"KC_CIRC": "KC_6",
+	"KC_AMPR": "KC_7",
+	"KC_ASTR": "KC_8",
+	"KC_LPRN": "KC_9",
+	"KC_RPRN": "KC_0",
+	"KC_UNDS": "KC_MINS",
+	"KC_PLUS": "KC_EQL",
+	"KC_LCBR": "KC_LBRC",
+	"KC_RCBR": "KC_RBRC",
+	"KC_PIPE": "KC_BSLS",
+	"KC_COLN": "KC_SCLN",
+	"KC_DQUO": "KC_QUOT",
+	"KC_LABK": "KC_COMM",
+	"KC_RABK": "KC_DOT",
+	"KC_QUES": "KC_SLSH",
+}
+
+// Legends for keys that don't type a character, by keycode
+// Anything else without a legend here gets its keycode without the KC_.
+var qmkLegends = map[string]string{
+	"KC_ESC":  "Esc",
+	"KC_TAB":  "Tab",
+	"KC_CAPS": "Caps",
+	"KC_LSFT": "Shift",
+	"KC_RSFT": "Shift",
+	"KC_LCTL": "Ctrl",
+	"KC_RCTL": "Ctrl",
+	"KC_LALT": "Alt",
+	"KC_RALT": "AltGr",
+	"KC_LGUI": "Gui",
+	"KC_RGUI": "Gui",
+	"KC_APP":  "Menu",
+	"KC_ENT":  "Enter",
+	"KC_BSPC": "Bksp",
+	"KC_SPC":  "Space",
+	"KC_DEL":  "Del",
+	"KC_INS":  "Ins",
+	"KC_HOME": "Home",
+	"KC_END":  "End",
+	"KC_PGUP": "PgUp",
+	"KC_PGDN": "PgDn",
+	"KC_PSCR": "PrtSc",
+	"KC_SCRL": "Scroll Lock",
+	"KC_PAUS": "Pause",
+	"KC_NUM":  "Num Lock",
+	"KC_UP":   "↑",
+	"KC_DOWN": "↓",
+	"KC_LEFT": "←",
+	"KC_RGHT": "→",
+}
+
+// Tri-layer keys, and the names of the layers they switch to
+var qmkTriLayerKeys = map[string]struct {
+	layer int
+	name  string
+}{
+	"TL_LOWR": {1, "lower"},
+	"TL_UPPR": {2, "raise"},
+}
+
+// Split a keycode like "LT(1, KC_SPC)" into its function and arguments
+func qmkFunction(code string) (string, []string, bool) {
+	name, args, ok := strings.Cut(code, "(")
+	if !ok || !strings.HasSuffix(args, ")") {
+		return "", nil, false
+	}
+	parts := strings.Split(strings.TrimSuffix(args, ")"), ",")
+	for i := range parts {
+		parts[i] = strings.TrimSpace(parts[i])
+	}
+	return name, parts, true
+}
+
+// Identity of the key a QMK keycode makes, and the legend to print on it
+// Layer keys (MO, TT, OSL and the layer-taps of LT) switch layers, mod-taps
+// are what they type when tapped, and keys wrapped in Shift type the shifted
+// character. Transparent keys and KC_NO have no identity or legend.
+func parseQMKKeycode(code string) (KeyIdentity, string) {
+	code = strings.TrimSpace(code)
+	code = cmp.Or(qmkAliases[code], code)
+
+	if name, args, ok := qmkFunction(code); ok {
+		layer, err := strconv.Atoi(args[0])
+		switch {
+		case (name == "MO" || name == "TT" || name == "OSL") && len(args) == 1 && err == nil:
+			return KeyIdentity{Code: fmt.Sprintf("MO(%d)", layer), Layer: layer}, fmt.Sprintf("MO(%d)", layer)
+		case name == "LT" && len(args) == 2 && err == nil:
+			id, legend := parseQMKKeycode(args[1])
+			id.Layer = layer
+			return id, legend
+		case name == "MT" && len(args) == 2, strings.HasSuffix(name, "_T") && len(args) == 1:
+			return parseQMKKeycode(args[len(args)-1])
+		case (name == "S" || name == "LSFT" || name == "RSFT") && len(args) == 1:
+			id, _ := parseQMKKeycode(args[0])
+			if id.Shifted == 0 {
+				return KeyIdentity{Code: code}, code
+			}
+			id = KeyIdentity{Code: code, Unshifted: id.Shifted}
+			return id, string(id.Unshifted)
+		}
+		return KeyIdentity{Code: code}, code
+	}
+
+	if tri, ok := qmkTriLayerKeys[code]; ok {
+		return KeyIdentity{Code: code, Layer: tri.layer}, strings.ToUpper(tri.name[:1]) + tri.name[1:]
+	}
+	if base, ok := qmkShifted[code]; ok {
+		r := usCharacters[base][1]
+		return KeyIdentity{Code: code, Unshifted: r}, string(r)
+	}
+
+	switch {
+	case code == "KC_TRNS" || code == "KC_NO":
+		return KeyIdentity{}, ""
+	case qmkLegends[code] != "":
+		chars := usCharacters[code]
+		return KeyIdentity{Code: code, Unshifted: chars[0], Shifted: chars[1]}, qmkLegends[code]
+	}
+	if chars, ok := usCharacters[code]; ok {
+		return KeyIdentity{Code: code, Unshifted: chars[0], Shifted: chars[1]}, string(unicode.ToUpper(chars[0]))
+	}
+	return KeyIdentity{Code: code}, strings.TrimPrefix(code, "KC_")
+}
+
+// Legends for a key from its keycode, in the same places as KLE's: letters
+// get just the upper case, other characters the shifted one above the
+// unshifted one and anything else its name
+func qmkLabels(id KeyIdentity, legend string) []string {
+	labels := make([]string, 12)
+	if id.IsPrintable() && id.Shifted != 0 && id.Shifted != unicode.ToUpper(id.Unshifted) {
+		labels[0], labels[6] = string(id.Shifted), string(id.Unshifted)
+		return labels
+	}
+	labels[0] = legend
+	return labels
+}
+
+// Parse a QMK info.json, and the keymap.json to go with it if there is one
+// The keymap picks the layout, falling back to the only one there is or one
+// called LAYOUT. Without a keymap, keys are identified by their labels in
+// the info.json, like KLE legends.
+func parseQMKKeyboard(infoData, keymapData []byte) (Keyboard, error) {
+	var info qmkInfo
+	if err := json5.Unmarshal(infoData, &info); err != nil {
+		return Keyboard{}, fmt.Errorf("failed to parse info.json: %w", err)
+	}
+	var keymap qmkKeymap
+	if keymapData != nil {
+		if err := json5.Unmarshal(keymapData, &keymap); err != nil {
+			return Keyboard{}, fmt.Errorf("failed to parse keymap.json: %w", err)
+		}
+	}
+
+	name, layout, err := info.layout(keymap.Layout)
+	if err != nil {
+		return Keyboard{}, err
+	}
+
+	var errs []error
+	for l, layer := range keymap.Layers {
+		if len(layer) != len(layout.Layout) {
+			errs = append(errs, fmt.Errorf("layer %d has %d keycodes, but %s has %d keys", l, len(layer), name, len(layout.Layout)))
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return Keyboard{}, err
+	}
+
+	keyboard := Keyboard{
+		Meta: KeyboardMetadata{Name: info.KeyboardName, Author: info.Manufacturer},
+		Keys: []Key{},
+	}
+	for i, k := range layout.Layout {
+		key := Key{
+			X:             k.X,
+			Y:             k.Y,
+			Width:         cmp.Or(k.W, 1),
+			Height:        cmp.Or(k.H, 1),
+			RotationAngle: k.R,
+			RotationX:     k.RX,
+			RotationY:     k.RY,
+			Alignment:     4,
+		}
+
+		if len(keymap.Layers) == 0 {
+			key.Labels = make([]string, 12)
+			key.Labels[0] = k.Label
+		} else {
+			codes := make([]string, len(keymap.Layers))
+			for l, layer := range keymap.Layers {
+				codes[l] = layer[i]
+			}
+			setQMKKeycodes(&key, codes)
+		}
+		keyboard.Keys = append(keyboard.Keys, key)
+	}
+
+	if len(keymap.Layers) == 0 {
+		resolveKeyIdentities(&keyboard)
+	} else {
+		keyboard.Layers = qmkLayerNames(keyboard)
+		rememberPrinted(&keyboard)
+	}
+	assignFingers(&keyboard, nil)
+
+	return keyboard, nil
+}
+
+// Give a key what its keycodes type, one per layer with the base layer
+// first, and legends to match
+func setQMKKeycodes(key *Key, codes []string) {
+	key.Identity, key.Layers = KeyIdentity{}, nil
+	for l, code := range codes {
+		id, legend := parseQMKKeycode(code)
+		switch {
+		case l == 0:
+			key.Identity, key.Labels = id, qmkLabels(id, legend)
+		default:
+			key.Layers = append(key.Layers, id)
+			if 8+l < len(key.Labels) {
+				key.Labels[8+l] = legend
+			}
+		}
+	}
+
+	// Nothing but transparent keys on layers is no layers at all
+	for len(key.Layers) > 0 && key.Layers[len(key.Layers)-1] == (KeyIdentity{}) {
+		key.Layers = key.Layers[:len(key.Layers)-1]
+	}
+	key.Nub = key.Identity.Code == "KC_F" || key.Identity.Code == "KC_J"
+}
+
+// The layout of a keyboard with the given name (or alias), or the default
+func (info qmkInfo) layout(name string) (string, qmkLayout, error) {
+	name = cmp.Or(info.LayoutAliases[name], name)
+	if name == "" {
+		switch names := sortedKeys(info.Layouts); {
+		case len(names) == 1:
+			name = names[0]
+		case len(names) > 1:
+			name = cmp.Or(info.LayoutAliases["LAYOUT"], "LAYOUT")
+			if _, ok := info.Layouts[name]; !ok {
+				name = names[0]
+			}
+		}
+	}
+
+	layout, ok := info.Layouts[name]
+	switch {
+	case len(info.Layouts) == 0:
+		return "", layout, errors.New("info.json has no layouts")
+	case !ok:
+		return "", layout, fmt.Errorf("info.json has no layout %q, try one of %s", name, strings.Join(sortedKeys(info.Layouts), ", "))
+	}
+	return name, layout, nil
+}
+
+// Keys of a map, sorted
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+// Names of the layers of a keyboard with keycodes, base first
+// Only layers that have something on them or a key to switch to them count.
+// Layers are numbered, except for the ones tri-layer keys switch to.
+func qmkLayerNames(kb Keyboard) []string {
+	n := 1
+	for _, key := range kb.Keys {
+		n = max(n, len(key.Layers)+1, key.Identity.Layer+1)
+	}
+	if n < 2 {
+		return nil
+	}
+
+	names := []string{"base"}
+	for layer := 1; layer < n; layer++ {
+		names = append(names, fmt.Sprintf("layer %d", layer))
+	}
+	for _, key := range kb.Keys {
+		if tri, ok := qmkTriLayerKeys[key.Identity.Code]; ok {
+			names[tri.layer] = tri.name
+		}
+	}
+	return names
+}
+
+// Find the info.json (or keyboard.json) for a keymap in dir
+// QMK keeps keymaps in keymaps/<name> below the keyboard, so that's looked
+// for in dir and the directories above it.
+func findQMKInfo(dir string) string {
+	for range 4 {
+		for _, name := range []string{"info.json", "keyboard.json"} {
+			if path := filepath.Join(dir, name); fileExists(path) {
+				return path
+			}
+		}
+		dir = filepath.Dir(dir)
+	}
+	return ""
+}
+
+// Find the keymap.json for an info.json in dir: one next to it, or the
+// default keymap. An empty path means there's none.
+func findQMKKeymap(dir string) string {
+	for _, path := range []string{
+		filepath.Join(dir, "keymap.json"),
+		filepath.Join(dir, "keymaps", "default", "keymap.json"),
+	} {
+		if fileExists(path) {
+			return path
+		}
+	}
+	return ""
+}
+
+// Whether a regular file exists at path
+func fileExists(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.Mode().IsRegular()
+}
+
+// The absolute form of path, or path itself if there isn't one
+func absPath(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return path
+}
+
+// Load a QMK keyboard from either its info.json or a keymap.json
+// The other file is found next to (or above) the given one, unless a keymap
+// is given.
+func loadQMKKeyboard(filename string, data []byte, keymapFile string) (Keyboard, error) {
+	var contents map[string]any
+	if err := json5.Unmarshal(data, &contents); err != nil {
+		return Keyboard{}, fmt.Errorf("failed to parse JSON5: %w", err)
+	}
+
+	infoData := data
+	if _, ok := contents["layers"]; ok {
+		keymapFile = filename
+		infoFile := findQMKInfo(filepath.Dir(filename))
+		if infoFile == "" {
+			return Keyboard{}, fmt.Errorf("no info.json or keyboard.json found for keymap %s", filename)
+		}
+		var err error
+		if infoData, err = os.ReadFile(infoFile); err != nil {
+			return Keyboard{}, fmt.Errorf("failed to read file: %w", err)
+		}
+	} else if keymapFile == "" {
+		keymapFile = findQMKKeymap(filepath.Dir(filename))
+	}
+
+	var keymapData []byte
+	if keymapFile != "" {
+		var err error
+		if keymapData, err = os.ReadFile(keymapFile); err != nil {
+			return Keyboard{}, fmt.Errorf("failed to read keymap: %w", err)
+		}
+	}
+
+	return parseQMKKeyboard(infoData, keymapData)
+}
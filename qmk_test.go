@@ -0,0 +1,206 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParseQMKKeycode(t *testing.T) {
+	tests := []struct {
+		code   string
+		id     KeyIdentity
+		legend string
+	}{
+		{"KC_A", KeyIdentity{Code: "KC_A", Unshifted: 'a', Shifted: 'A'}, "A"},
+		{" KC_1 ", KeyIdentity{Code: "KC_1", Unshifted: '1', Shifted: '!'}, "1"},
+		{"KC_SPACE", KeyIdentity{Code: "KC_SPC", Unshifted: ' ', Shifted: ' '}, "Space"},
+		{"KC_ESCAPE", KeyIdentity{Code: "KC_ESC"}, "Esc"},
+		{"KC_F1", KeyIdentity{Code: "KC_F1"}, "F1"},
+		{"RGB_TOG", KeyIdentity{Code: "RGB_TOG"}, "RGB_TOG"},
+
+		// Shifted characters, on their own or wrapped in Shift
+		{"KC_EXLM", KeyIdentity{Code: "KC_EXLM", Unshifted: '!'}, "!"},
+		{"KC_COLON", KeyIdentity{Code: "KC_COLN", Unshifted: ':'}, ":"},
+		{"S(KC_1)", KeyIdentity{Code: "S(KC_1)", Unshifted: '!'}, "!"},
+		{"LSFT(KC_A)", KeyIdentity{Code: "LSFT(KC_A)", Unshifted: 'A'}, "A"},
+		{"S(KC_F1)", KeyIdentity{Code: "S(KC_F1)"}, "S(KC_F1)"},
+
+		// Layer keys
+		{"MO(1)", KeyIdentity{Code: "MO(1)", Layer: 1}, "MO(1)"},
+		{"TT(2)", KeyIdentity{Code: "MO(2)", Layer: 2}, "MO(2)"},
+		{"OSL(3)", KeyIdentity{Code: "MO(3)", Layer: 3}, "MO(3)"},
+		{"LT(1, KC_SPC)", KeyIdentity{Code: "KC_SPC", Unshifted: ' ', Shifted: ' ', Layer: 1}, "Space"},
+		{"TL_LOWR", KeyIdentity{Code: "TL_LOWR", Layer: 1}, "Lower"},
+		{"TL_UPPR", KeyIdentity{Code: "TL_UPPR", Layer: 2}, "Raise"},
+		{"MO(x)", KeyIdentity{Code: "MO(x)"}, "MO(x)"},
+
+		// Mod-taps are what they type when tapped
+		{"MT(MOD_LCTL, KC_A)", KeyIdentity{Code: "KC_A", Unshifted: 'a', Shifted: 'A'}, "A"},
+		{"LCTL_T(KC_ESC)", KeyIdentity{Code: "KC_ESC"}, "Esc"},
+		{"LGUI_T(KC_SCLN)", KeyIdentity{Code: "KC_SCLN", Unshifted: ';', Shifted: ':'}, ";"},
+
+		// Nothing at all
+		{"KC_TRNS", KeyIdentity{}, ""},
+		{"_______", KeyIdentity{}, ""},
+		{"XXXXXXX", KeyIdentity{}, ""},
+		{"KC_NO", KeyIdentity{}, ""},
+	}
+	for _, test := range tests {
+		id, legend := parseQMKKeycode(test.code)
+		if id != test.id || legend != test.legend {
+			t.Errorf("parseQMKKeycode(%q) = %+v, %q, want %+v, %q", test.code, id, legend, test.id, test.legend)
+		}
+	}
+}
+
+func TestQMKLayout(t *testing.T) {
+	layouts := func(names ...string) map[string]qmkLayout {
+		m := make(map[string]qmkLayout)
+		for _, name := range names {
+			m[name] = qmkLayout{}
+		}
+		return m
+	}
+	tests := []struct {
+		info qmkInfo
+		name string
+		want string // Empty for an error
+	}{
+		{qmkInfo{Layouts: layouts("LAYOUT_ortho")}, "", "LAYOUT_ortho"},
+		{qmkInfo{Layouts: layouts("LAYOUT_b", "LAYOUT", "LAYOUT_a")}, "", "LAYOUT"},
+		{qmkInfo{Layouts: layouts("LAYOUT_b", "LAYOUT_a")}, "", "LAYOUT_a"},
+		{qmkInfo{Layouts: layouts("LAYOUT_b", "LAYOUT_a"), LayoutAliases: map[string]string{"LAYOUT": "LAYOUT_b"}}, "", "LAYOUT_b"},
+		{qmkInfo{Layouts: layouts("LAYOUT_b", "LAYOUT", "LAYOUT_a")}, "LAYOUT_b", "LAYOUT_b"},
+		{qmkInfo{Layouts: layouts("LAYOUT_b", "LAYOUT_a"), LayoutAliases: map[string]string{"LAYOUT_60": "LAYOUT_a"}}, "LAYOUT_60", "LAYOUT_a"},
+		{qmkInfo{Layouts: layouts("LAYOUT_b", "LAYOUT_a")}, "LAYOUT_c", ""},
+		{qmkInfo{}, "", ""},
+	}
+	for _, test := range tests {
+		name, _, err := test.info.layout(test.name)
+		switch {
+		case test.want == "" && err == nil:
+			t.Errorf("layout(%q) of %v = %q, want an error", test.name, sortedKeys(test.info.Layouts), name)
+		case test.want != "" && name != test.want:
+			t.Errorf("layout(%q) of %v = %q (%v), want %q", test.name, sortedKeys(test.info.Layouts), name, err, test.want)
+		}
+	}
+
+	// The error says what there is instead
+	info := qmkInfo{Layouts: layouts("LAYOUT_b", "LAYOUT_a")}
+	if _, _, err := info.layout("LAYOUT_c"); err == nil || !strings.Contains(err.Error(), "LAYOUT_a, LAYOUT_b") {
+		t.Errorf("missing layout error = %v, want the layouts listed", err)
+	}
+}
+
+const (
+	qmkTestInfo = `{
+		// A numpad sized test keyboard
+		keyboard_name: "Tiny",
+		manufacturer: "Test",
+		layout_aliases: {LAYOUT: "LAYOUT_ortho"},
+		layouts: {
+			LAYOUT_ortho: {layout: [
+				{label: "A", matrix: [0, 0], x: 0, y: 0},
+				{label: "B", matrix: [0, 1], x: 1, y: 0},
+				{label: ";", matrix: [0, 2], x: 2, y: 0},
+				{label: "Space", matrix: [1, 0], x: 0, y: 1, w: 2},
+				{label: "Lower", matrix: [1, 2], x: 2, y: 1},
+			]},
+			LAYOUT_small: {layout: [{x: 0, y: 0}]},
+		},
+	}`
+	qmkTestKeymap = `{
+		"keyboard": "tiny",
+		"keymap": "default",
+		"layout": "LAYOUT",
+		"layers": [
+			["KC_A", "KC_B", "KC_SEMICOLON", "LT(2, KC_SPC)", "TL_LOWR"],
+			["KC_1", "S(KC_1)", "_______", "_______", "_______"],
+			["KC_2", "_______", "_______", "_______", "_______"]
+		]
+	}`
+)
+
+func TestLoadQMKKeyboard(t *testing.T) {
+	dir := t.TempDir()
+	infoFile := filepath.Join(dir, "info.json")
+	keymapFile := filepath.Join(dir, "keymaps", "default", "keymap.json")
+	if err := os.MkdirAll(filepath.Dir(keymapFile), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for file, data := range map[string]string{infoFile: qmkTestInfo, keymapFile: qmkTestKeymap} {
+		if err := os.WriteFile(file, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Either file finds the other
+	for _, file := range []string{infoFile, keymapFile} {
+		kb, err := loadKeyboard(file, KeyboardOptions{})
+		if err != nil {
+			t.Fatalf("loading %s: %v", file, err)
+		}
+
+		if kb.Meta.Name != "Tiny" || len(kb.Keys) != 5 {
+			t.Fatalf("loading %s: %q with %d keys, want Tiny with 5", file, kb.Meta.Name, len(kb.Keys))
+		}
+		if want := []string{"base", "lower", "layer 2"}; !slices.Equal(kb.Layers, want) {
+			t.Errorf("loading %s: layers %q, want %q", file, kb.Layers, want)
+		}
+
+		want := []struct {
+			code   string
+			layers []string
+			labels [2]string
+		}{
+			{"KC_A", []string{"KC_1", "KC_2"}, [2]string{"A", ""}},
+			{"KC_B", []string{"S(KC_1)"}, [2]string{"B", ""}},
+			{"KC_SCLN", nil, [2]string{":", ";"}},
+			{"KC_SPC", nil, [2]string{"Space", ""}},
+			{"TL_LOWR", nil, [2]string{"Lower", ""}},
+		}
+		for i, key := range kb.Keys {
+			var layers []string
+			for _, id := range key.Layers {
+				layers = append(layers, id.Code)
+			}
+			labels := [2]string{key.Labels[0], key.Labels[6]}
+			if key.Identity.Code != want[i].code || !slices.Equal(layers, want[i].layers) || labels != want[i].labels {
+				t.Errorf("loading %s: key %d is %s on layers %v labelled %q, want %s on %v labelled %q",
+					file, i, key.Identity.Code, layers, labels, want[i].code, want[i].layers, want[i].labels)
+			}
+		}
+		if kb.Keys[3].Width != 2 || kb.Keys[3].Identity.Layer != 2 {
+			t.Errorf("loading %s: space is %gu wide and switches to layer %d, want 2u and 2", file, kb.Keys[3].Width, kb.Keys[3].Identity.Layer)
+		}
+		for r, layer := range map[rune]int{'a': 0, '1': 1, '!': 1, '2': 2} {
+			if got := kb.LayerFor(r); got != layer {
+				t.Errorf("loading %s: LayerFor(%q) = %d, want %d", file, r, got, layer)
+			}
+		}
+	}
+}
+
+func TestParseQMKKeyboard(t *testing.T) {
+	// Without a keymap, keys are known by their labels
+	kb, err := parseQMKKeyboard([]byte(qmkTestInfo), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kb.Keys[0].Identity.Code != "KC_A" || kb.Keys[3].Identity.Code != "KC_SPC" || kb.Layers != nil {
+		t.Errorf("keys %s and %s with layers %q, want KC_A and KC_SPC without layers", kb.Keys[0].Identity.Code, kb.Keys[3].Identity.Code, kb.Layers)
+	}
+
+	// A keymap has to fit its layout
+	keymap := `{"layout": "LAYOUT_small", "layers": [["KC_A", "KC_B"]]}`
+	if _, err := parseQMKKeyboard([]byte(qmkTestInfo), []byte(keymap)); err == nil || !strings.Contains(err.Error(), "LAYOUT_small has 1 keys") {
+		t.Errorf("keymap with too many keycodes: %v", err)
+	}
+	keymap = `{"layout": "LAYOUT_big", "layers": [["KC_A"]]}`
+	if _, err := parseQMKKeyboard([]byte(qmkTestInfo), []byte(keymap)); err == nil || !strings.Contains(err.Error(), `no layout "LAYOUT_big"`) {
+		t.Errorf("keymap for a missing layout: %v", err)
+	}
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	_ "embed"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// Where the prompts to type come from
+type PromptSource interface {
+	Next() string // The next prompt, never empty
+}
+
+// A prompt source with a fixed number of prompts, like most lessons
+type sizedSource interface {
+	PromptSource
+	Len() int
+}
+
+// Words in made up prompts
+const sourceWords = 12
+
+// Common English words, most frequent first
+// Ranked by words/rank.go from Wikipedia, a novel and TV and film scripts.
+// The top 200 and 1k lists are the start of this one.
+//
+//go:embed words/english-10k.txt
+var englishWordList string
+
+var englishWords = strings.Fields(englishWordList)
+
+// Frequency lists for the words source, by name
+var wordLists = map[string][]string{
+	"200": englishWords[:200],
+	"1k":  englishWords[:1000],
+	"10k": englishWords,
+}
+
+// Quotes that come with the application, one per line
+//
+//go:embed quotes/quotes.txt
+var builtinQuotes string
+
+// A random number generator for made up prompts
+// A seed of 0 picks a random one, any other seed makes the same prompts
+// (and drills for the same statistics) every time.
+func newRand(seed uint64) *rand.Rand {
+	if seed == 0 {
+		seed = rand.Uint64()
+	}
+	return rand.New(rand.NewPCG(seed, seed))
+}
+
+// Cycles through a list of prompts
+// With refill set, a new list is made up after the last prompt.
+type listSource struct {
+	prompts []string
+	next    int
+	refill  func() []string
+}
+
+func (s *listSource) Next() string {
+	if s.next == len(s.prompts) {
+		s.next = 0
+		if s.refill != nil {
+			s.prompts = s.refill()
+		}
+	}
+	s.next++
+	return s.prompts[s.next-1]
+}
+
+func (s *listSource) Len() int {
+	return len(s.prompts)
+}
+
+// Random words from a word list
+type wordSource struct {
+	words []string
+	rng   *rand.Rand
+}
+
+func (s *wordSource) Next() string {
+	words := make([]string, sourceWords)
+	for i := range words {
+		words[i] = s.words[s.rng.IntN(len(s.words))]
+	}
+	return strings.Join(words, " ")
+}
+
+// Made up words of random characters, between minLength and maxLength long
+type randomWordSource struct {
+	chars     []rune
+	words     int
+	minLength int
+	maxLength int
+	rng       *rand.Rand
+}
+
+func (s *randomWordSource) Next() string {
+	words := make([]string, s.words)
+	for i := range words {
+		word := make([]rune, s.minLength+s.rng.IntN(s.maxLength-s.minLength+1))
+		for j := range word {
+			word[j] = s.chars[s.rng.IntN(len(s.chars))]
+		}
+		words[i] = string(word)
+	}
+	return strings.Join(words, " ")
+}
+
+// Random quotes, never the same one twice in a row
+type quoteSource struct {
+	quotes []string
+	last   int
+	rng    *rand.Rand
+}
+
+func (s *quoteSource) Next() string {
+	i := s.rng.IntN(len(s.quotes))
+	if i == s.last && len(s.quotes) > 1 {
+		i = (i + 1) % len(s.quotes)
+	}
+	s.last = i
+	return s.quotes[i]
+}
+
+// Parse a quotes file: one quote per line, skipping blank lines and
+// comments starting with #
+func parseQuotes(text string) []string {
+	var quotes []string
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.Join(strings.Fields(line), " ")
+		if line != "" && !strings.HasPrefix(line, "#") {
+			quotes = append(quotes, line)
+		}
+	}
+	return quotes
+}
+
+// Load quotes from a file, or the built-in ones if path is empty
+func loadQuoteSource(path string, rng *rand.Rand) (*quoteSource, error) {
+	text := builtinQuotes
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read quotes: %w", err)
+		}
+		text = string(data)
+	}
+
+	quotes := parseQuotes(text)
+	if len(quotes) == 0 {
+		return nil, fmt.Errorf("no quotes in %s", path)
+	}
+	return &quoteSource{quotes: quotes, last: -1, rng: rng}, nil
+}
+
+// Abbreviations that don't end a sentence
+var abbreviations = []string{"mr", "mrs", "ms", "dr", "st", "jr", "sr", "prof", "vs", "etc", "e.g", "i.e"}
+
+// Split text into sentences, ending at ., ! or ? (and any closing quotes
+// or brackets) followed by whitespace and anything but a lowercase letter,
+// so `"Wait!" she said.` stays together
+func splitSentences(text string) []string {
+	runes := []rune(strings.Join(strings.Fields(text), " "))
+
+	var sentences []string
+	start := 0
+	for i, r := range runes {
+		if !strings.ContainsRune(".!?", r) {
+			continue
+		}
+		end := i + 1
+		for end < len(runes) && strings.ContainsRune(`"')]`+"”’", runes[end]) {
+			end++
+		}
+		if end < len(runes) && (!unicode.IsSpace(runes[end]) || end+1 < len(runes) && unicode.IsLower(runes[end+1])) {
+			continue
+		}
+		word := string(runes[start:i])
+		word = word[strings.LastIndex(word, " ")+1:]
+		if r == '.' && slices.Contains(abbreviations, strings.ToLower(word)) {
+			continue
+		}
+		if sentence := strings.TrimSpace(string(runes[start:end])); sentence != "" {
+			sentences = append(sentences, sentence)
+		}
+		start = end
+	}
+	if rest := strings.TrimSpace(string(runes[start:])); rest != "" {
+		sentences = append(sentences, rest)
+	}
+
+	return sentences
+}
+
+// Load a text file as a list of sentences, typed in order
+func loadTextSource(path string) (*listSource, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read text: %w", err)
+	}
+
+	sentences := splitSentences(string(data))
+	if len(sentences) == 0 {
+		return nil, errors.New("no text in " + path)
+	}
+	return &listSource{prompts: sentences}, nil
+}
+
+// The prompt source for a lesson
+// Generated lessons make up new prompts after the last one, and drills a
+// new one every time, from the statistics so far. Positional keys are
+// translated to the logical layout first.
+func (m Model) lessonSource(lesson Lesson) PromptSource {
+	if lesson.Positional {
+		lesson.Keys = layouts[m.config.Layout].translate(lesson.Keys)
+	}
+
+	switch {
+	case lesson.Drill != nil:
+		return &drillSource{
+			drill:   *lesson.Drill,
+			keys:    m.keyStats,
+			bigrams: m.bigramStats,
+			rng:     newRand(m.config.Typing.DrillSeed),
+		}
+	case lesson.Generate != nil:
+		return &listSource{prompts: lesson.PromptList(), refill: lesson.PromptList}
+	}
+	return &listSource{prompts: lesson.Prompts}
+}
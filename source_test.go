@@ -0,0 +1,136 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestSplitSentences(t *testing.T) {
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{"One. Two! Three?", []string{"One.", "Two!", "Three?"}},
+		{`"Wait!" she said. Then she left.`, []string{`"Wait!" she said.`, "Then she left."}},
+		{"Mr. Smith met Dr. Jones. They talked.", []string{"Mr. Smith met Dr. Jones.", "They talked."}},
+		{"Bring fruit, e.g. apples. And bread", []string{"Bring fruit, e.g. apples.", "And bread"}},
+		{"Version 1.5 is out. Get it", []string{"Version 1.5 is out.", "Get it"}},
+		{"It ended (finally.) Then", []string{"It ended (finally.)", "Then"}},
+		{"Line one\n  still going.\n\nTwo", []string{"Line one still going.", "Two"}},
+		{"No end", []string{"No end"}},
+		{" \n ", nil},
+	}
+	for _, test := range tests {
+		if got := splitSentences(test.text); !slices.Equal(got, test.want) {
+			t.Errorf("splitSentences(%q) = %q, want %q", test.text, got, test.want)
+		}
+	}
+}
+
+func TestParseQuotes(t *testing.T) {
+	got := parseQuotes("# A comment\nFirst  quote\n\n  Second: a/b  \n")
+	if want := []string{"First quote", "Second: a/b"}; !slices.Equal(got, want) {
+		t.Errorf("parseQuotes = %q, want %q", got, want)
+	}
+}
+
+func TestWordLists(t *testing.T) {
+	if len(wordLists["200"]) != 200 || len(wordLists["1k"]) != 1000 || len(wordLists["10k"]) != 10000 {
+		t.Errorf("word lists have %d, %d and %d words", len(wordLists["200"]), len(wordLists["1k"]), len(wordLists["10k"]))
+	}
+	// Most frequent first
+	for _, word := range []string{"the", "and", "of", "you"} {
+		if !slices.Contains(wordLists["200"], word) {
+			t.Errorf("%q isn't in the top 200", word)
+		}
+	}
+	seen := make(map[string]bool)
+	for _, word := range englishWords {
+		if seen[word] {
+			t.Errorf("%q is in the list twice", word)
+		}
+		seen[word] = true
+	}
+}
+
+func TestWordSource(t *testing.T) {
+	s := &wordSource{words: wordLists["200"], rng: newRand(1)}
+	for range 10 {
+		words := strings.Fields(s.Next())
+		if len(words) != sourceWords {
+			t.Errorf("%d words, want %d", len(words), sourceWords)
+		}
+		for _, word := range words {
+			if !slices.Contains(wordLists["200"], word) {
+				t.Errorf("%q isn't in the list", word)
+			}
+		}
+	}
+}
+
+func TestRandomWordSource(t *testing.T) {
+	tests := []struct{ min, max int }{{2, 4}, {3, 3}, {1, 7}}
+	for _, test := range tests {
+		s := &randomWordSource{chars: []rune("ab"), words: 100, minLength: test.min, maxLength: test.max, rng: newRand(1)}
+		lengths := make(map[int]bool)
+		for _, word := range strings.Fields(s.Next()) {
+			if len(word) < test.min || len(word) > test.max || strings.Trim(word, "ab") != "" {
+				t.Errorf("%d-%d letters: got %q", test.min, test.max, word)
+			}
+			lengths[len(word)] = true
+		}
+		if len(lengths) != test.max-test.min+1 {
+			t.Errorf("%d-%d letters: only lengths %v", test.min, test.max, lengths)
+		}
+	}
+}
+
+func TestSourceCommand(t *testing.T) {
+	dir := t.TempDir()
+	textFile := filepath.Join(dir, "my text.txt")
+	if err := os.WriteFile(textFile, []byte("First one. Second one."), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		args string
+		name string // Empty for an error
+	}{
+		{"words", "Words: top 1k"},
+		{"words 200", "Words: top 200"},
+		{"words 5k", ""},
+		{"words 200 1k", ""},
+		{"random", "Random words: 2-7 letters"},
+		{"random 3", "Random words: 3-7 letters"},
+		{"random 9", "Random words: 9-9 letters"},
+		{"random 2 4", "Random words: 2-4 letters"},
+		{"random 5 3", ""},
+		{"random 0", ""},
+		{"random x", ""},
+		{"random 1 2 3", ""},
+		{"quotes", "Quotes"},
+		{"quotes " + filepath.Join(dir, "missing.txt"), ""},
+		{"text " + textFile, "Text: my text.txt"},
+		{"text", ""},
+		{"bogus", ""},
+	}
+	for _, test := range tests {
+		m := Model{config: DefaultConfig(), rng: newRand(1), sourceName: "Before", pressedKeys: make(map[int]int)}
+		m, _ = m.handleSourceCommand(test.args)
+		switch {
+		case test.name == "" && m.sourceName != "Before":
+			t.Errorf("source %s: switched to %q, want an error", test.args, m.sourceName)
+		case test.name != "" && m.sourceName != test.name:
+			t.Errorf("source %s: %q (%s), want %q", test.args, m.sourceName, m.commandError, test.name)
+		}
+	}
+
+	m := Model{config: DefaultConfig(), rng: newRand(1), pressedKeys: make(map[int]int)}
+	m, _ = m.handleSourceCommand("text " + textFile)
+	if target := m.session.Target(); target != "First one." {
+		t.Errorf("first prompt of the text = %q", target)
+	}
+}
@@ -1,74 +1,324 @@
 package main
 
 import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
 	"github.com/charmbracelet/lipgloss"
+	"github.com/yosuke-furukawa/json5/encoding/json5"
+)
+
+// Colors used by every style in the application
+// Colors can be ANSI 256 color numbers ("212") or hex values ("#ff87d7").
+type Theme struct {
+	Name string `json:"name"`
+
+	Primary   lipgloss.Color `json:"primary"`   // Titles
+	Secondary lipgloss.Color `json:"secondary"` // Borders and selections
+	Selected  lipgloss.Color `json:"selected"`  // Text of selected menu items
+	Muted     lipgloss.Color `json:"muted"`     // Help text
+	Error     lipgloss.Color `json:"error"`
+	Success   lipgloss.Color `json:"success"` // Results
+
+	StatusBackground  lipgloss.Color `json:"status_background"`
+	StatusForeground  lipgloss.Color `json:"status_foreground"`
+	CommandBackground lipgloss.Color `json:"command_background"`
+
+	// Prompt characters
+	Correct           lipgloss.Color `json:"correct"`
+	Incorrect         lipgloss.Color `json:"incorrect"`
+	Current           lipgloss.Color `json:"current"`
+	CurrentBackground lipgloss.Color `json:"current_background"`
+	Future            lipgloss.Color `json:"future"`
+
+	// Onscreen keyboard
+	KeyForeground lipgloss.Color `json:"key_foreground"`
+	KeyBackground lipgloss.Color `json:"key_background"` // Only used for keys without a border
+	KeySpecial    lipgloss.Color `json:"key_special"`    // Modifiers, Enter, etc.
+	KeyPressed    lipgloss.Color `json:"key_pressed"`
+	KeyPressedFG  lipgloss.Color `json:"key_pressed_foreground"`
+	KeyHint       lipgloss.Color `json:"key_hint"` // Keys to press next
+
+	// Finger colors from pinky to thumb, the same for both hands
+	Fingers [5]lipgloss.Color `json:"fingers"`
+}
+
+// Built-in themes
+var (
+	defaultTheme = Theme{
+		Name:              "default",
+		Primary:           "212",
+		Secondary:         "63",
+		Selected:          "230",
+		Muted:             "241",
+		Error:             "196",
+		Success:           "46",
+		StatusBackground:  "236",
+		StatusForeground:  "255",
+		CommandBackground: "234",
+		Correct:           "46",
+		Incorrect:         "196",
+		Current:           "226",
+		CurrentBackground: "240",
+		Future:            "244",
+		KeyForeground:     "255",
+		KeyBackground:     "237",
+		KeySpecial:        "33",
+		KeyPressed:        "46",
+		KeyPressedFG:      "0",
+		KeyHint:           "214",
+		Fingers:           [5]lipgloss.Color{"168", "179", "71", "68", "140"},
+	}
+
+	lightTheme = Theme{
+		Name:              "light",
+		Primary:           "90",
+		Secondary:         "25",
+		Selected:          "231",
+		Muted:             "245",
+		Error:             "160",
+		Success:           "28",
+		StatusBackground:  "254",
+		StatusForeground:  "235",
+		CommandBackground: "252",
+		Correct:           "28",
+		Incorrect:         "160",
+		Current:           "232",
+		CurrentBackground: "222",
+		Future:            "246",
+		KeyForeground:     "235",
+		KeyBackground:     "253",
+		KeySpecial:        "25",
+		KeyPressed:        "28",
+		KeyPressedFG:      "231",
+		KeyHint:           "172",
+		Fingers:           [5]lipgloss.Color{"168", "172", "35", "32", "97"},
+	}
+
+	highContrastTheme = Theme{
+		Name:              "high-contrast",
+		Primary:           "15",
+		Secondary:         "15",
+		Selected:          "0",
+		Muted:             "15",
+		Error:             "9",
+		Success:           "10",
+		StatusBackground:  "15",
+		StatusForeground:  "0",
+		CommandBackground: "0",
+		Correct:           "10",
+		Incorrect:         "9",
+		Current:           "0",
+		CurrentBackground: "11",
+		Future:            "15",
+		KeyForeground:     "15",
+		KeyBackground:     "0",
+		KeySpecial:        "14",
+		KeyPressed:        "11",
+		KeyPressedFG:      "0",
+		KeyHint:           "13",
+		Fingers:           [5]lipgloss.Color{"13", "11", "10", "14", "12"},
+	}
+
+	// See: https://ethanschoonover.com/solarized/
+	solarizedTheme = Theme{
+		Name:              "solarized",
+		Primary:           "#d33682",
+		Secondary:         "#268bd2",
+		Selected:          "#fdf6e3",
+		Muted:             "#586e75",
+		Error:             "#dc322f",
+		Success:           "#859900",
+		StatusBackground:  "#073642",
+		StatusForeground:  "#93a1a1",
+		CommandBackground: "#002b36",
+		Correct:           "#859900",
+		Incorrect:         "#dc322f",
+		Current:           "#002b36",
+		CurrentBackground: "#b58900",
+		Future:            "#657b83",
+		KeyForeground:     "#93a1a1",
+		KeyBackground:     "#073642",
+		KeySpecial:        "#2aa198",
+		KeyPressed:        "#859900",
+		KeyPressedFG:      "#002b36",
+		KeyHint:           "#cb4b16",
+		Fingers:           [5]lipgloss.Color{"#d33682", "#b58900", "#859900", "#268bd2", "#6c71c4"},
+	}
 )
 
+// All themes that can be selected, by name
+var themes = map[string]Theme{
+	defaultTheme.Name:      defaultTheme,
+	lightTheme.Name:        lightTheme,
+	highContrastTheme.Name: highContrastTheme,
+	solarizedTheme.Name:    solarizedTheme,
+}
+
+// Names of all available themes, sorted
+func themeNames() []string {
+	var names []string
+	for name := range themes {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// Load user-defined themes from JSON files in dir
+// Colors left out of a theme file are taken from the default theme, and the
+// name defaults to the file name. A missing directory isn't an error.
+func loadThemes(dir string) error {
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return fmt.Errorf("failed to read theme: %w", err)
+		}
+
+		theme := defaultTheme
+		theme.Name = strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+		if err := json5.Unmarshal(data, &theme); err != nil {
+			return fmt.Errorf("failed to parse theme %s: %w", file, err)
+		}
+
+		themes[theme.Name] = theme
+	}
+
+	return nil
+}
+
 // Styles
 var (
+	titleStyle            lipgloss.Style
+	errorStyle            lipgloss.Style
+	menuStyle             lipgloss.Style
+	menuItemStyle         lipgloss.Style
+	selectedMenuItemStyle lipgloss.Style
+	contentStyle          lipgloss.Style
+	helpStyle             lipgloss.Style
+	statusLineStyle       lipgloss.Style
+	commandLineStyle      lipgloss.Style
+	commandErrorStyle     lipgloss.Style
+	resultsStyle          lipgloss.Style
+
+	// Prompt
+	promptStyle    lipgloss.Style
+	correctStyle   lipgloss.Style
+	incorrectStyle lipgloss.Style
+	currentStyle   lipgloss.Style
+	futureStyle    lipgloss.Style
+
+	// Onscreen keyboard
+	normalKeyStyle  lipgloss.Style
+	pressedKeyStyle lipgloss.Style
+	hintKeyStyle    lipgloss.Style
+	specialKeyStyle lipgloss.Style
+	keycapStyle     lipgloss.Style // Background for keys drawn without a border
+
+	// The theme the styles were last built from
+	currentTheme Theme
+)
+
+func init() {
+	ApplyTheme(defaultTheme)
+}
+
+// Rebuild all styles with the theme's colors
+func ApplyTheme(theme Theme) {
+	currentTheme = theme
+
 	titleStyle = lipgloss.NewStyle().
-			Bold(true).
-			Foreground(lipgloss.Color("212")).
-			BorderStyle(lipgloss.NormalBorder()).
-			BorderForeground(lipgloss.Color("63")).
-			Padding(0, 1)
+		Bold(true).
+		Foreground(theme.Primary).
+		BorderStyle(lipgloss.NormalBorder()).
+		BorderForeground(theme.Secondary).
+		Padding(0, 1)
 
 	errorStyle = lipgloss.NewStyle().
-			Bold(true).
-			Foreground(lipgloss.Color("196")).
-			BorderStyle(lipgloss.DoubleBorder()).
-			BorderForeground(lipgloss.Color("196")).
-			Padding(1, 2).
-			Margin(1, 0)
+		Bold(true).
+		Foreground(theme.Error).
+		BorderStyle(lipgloss.DoubleBorder()).
+		BorderForeground(theme.Error).
+		Padding(1, 2).
+		Margin(1, 0)
 
 	menuStyle = lipgloss.NewStyle().
-			Padding(1, 2).
-			Border(lipgloss.NormalBorder()).
-			BorderForeground(lipgloss.Color("63"))
+		Padding(1, 2).
+		Border(lipgloss.NormalBorder()).
+		BorderForeground(theme.Secondary)
 
 	menuItemStyle = lipgloss.NewStyle().
-			Padding(0, 2)
+		Padding(0, 2)
 
 	selectedMenuItemStyle = lipgloss.NewStyle().
-				Background(lipgloss.Color("63")).
-				Foreground(lipgloss.Color("230")).
-				Padding(0, 2).
-				Bold(true)
+		Background(theme.Secondary).
+		Foreground(theme.Selected).
+		Padding(0, 2).
+		Bold(true)
 
 	contentStyle = lipgloss.NewStyle().
-			Padding(1, 2).
-			Margin(1, 0)
+		Padding(1, 2).
+		Margin(1, 0)
 
 	helpStyle = lipgloss.NewStyle().
-			Foreground(lipgloss.Color("241")).
-			Margin(1, 0)
+		Foreground(theme.Muted).
+		Margin(1, 0)
 
 	statusLineStyle = lipgloss.NewStyle().
-			Background(lipgloss.Color("236")).
-			Foreground(lipgloss.Color("255")).
-			Padding(0, 1).
-			Bold(true)
+		Background(theme.StatusBackground).
+		Foreground(theme.StatusForeground).
+		Padding(0, 1).
+		Bold(true)
 
 	commandLineStyle = lipgloss.NewStyle().
-				Background(lipgloss.Color("234")).
-				Foreground(lipgloss.Color("255")).
-				Padding(0, 1)
+		Background(theme.CommandBackground).
+		Foreground(theme.StatusForeground).
+		Padding(0, 1)
 
 	commandErrorStyle = lipgloss.NewStyle().
-				Background(lipgloss.Color("196")).
-				Foreground(lipgloss.Color("255")).
-				Padding(0, 1).
-				Bold(true)
-)
+		Background(theme.Error).
+		Foreground(theme.StatusForeground).
+		Padding(0, 1).
+		Bold(true)
+
+	resultsStyle = lipgloss.NewStyle().
+		Bold(true).
+		Foreground(theme.Success)
+
+	promptStyle = lipgloss.NewStyle().
+		Border(lipgloss.RoundedBorder()).
+		BorderForeground(theme.Secondary).
+		Padding(1, 2).
+		Margin(1)
+
+	correctStyle = lipgloss.NewStyle().Foreground(theme.Correct)
+	incorrectStyle = lipgloss.NewStyle().Foreground(theme.Incorrect)
+	currentStyle = lipgloss.NewStyle().Foreground(theme.Current).Background(theme.CurrentBackground)
+	futureStyle = lipgloss.NewStyle().Foreground(theme.Future)
+
+	normalKeyStyle = lipgloss.NewStyle().
+		Foreground(theme.KeyForeground)
+
+	pressedKeyStyle = lipgloss.NewStyle().
+		Background(theme.KeyPressed).
+		Foreground(theme.KeyPressedFG)
+
+	hintKeyStyle = lipgloss.NewStyle().
+		Background(theme.KeyHint).
+		Foreground(theme.KeyPressedFG)
+
+	specialKeyStyle = lipgloss.NewStyle().
+		Foreground(theme.KeySpecial)
 
-// TODO: Add theme support
-// type Theme struct {
-//     Primary   lipgloss.Color
-//     Secondary lipgloss.Color
-//     Error     lipgloss.Color
-//     // ... other colors
-// }
-//
-// func ApplyTheme(theme Theme) {
-//     // Update all styles with theme colors
-// }
+	keycapStyle = lipgloss.NewStyle().
+		Background(theme.KeyBackground).
+		Foreground(theme.KeyForeground)
+}
@@ -0,0 +1,100 @@
+package typing
+
+import (
+	"time"
+)
+
+// Accuracy and speed statistics for a single expected character
+type KeyStat struct {
+	Hits    int           `json:"hits"`
+	Misses  int           `json:"misses"`
+	Latency time.Duration `json:"latency"` // Total time since the previous keystroke
+	Timed   int           `json:"timed"`   // Keystrokes included in Latency
+}
+
+// Fraction of key presses that were wrong, 0 if there were none
+func (k KeyStat) ErrorRate() float64 {
+	total := k.Hits + k.Misses
+	if total == 0 {
+		return 0
+	}
+	return float64(k.Misses) / float64(total)
+}
+
+// Average time it took to reach this key from the previous one
+func (k KeyStat) MeanLatency() time.Duration {
+	if k.Timed == 0 {
+		return 0
+	}
+	return k.Latency / time.Duration(k.Timed)
+}
+
+// Combine two sets of statistics for the same character
+func (k KeyStat) Add(other KeyStat) KeyStat {
+	return KeyStat{
+		Hits:    k.Hits + other.Hits,
+		Misses:  k.Misses + other.Misses,
+		Latency: k.Latency + other.Latency,
+		Timed:   k.Timed + other.Timed,
+	}
+}
+
+// Statistics keyed by the expected character
+type KeyStats map[string]KeyStat
+
+// Add the statistics from other into k
+func (k KeyStats) Merge(other KeyStats) {
+	for char, stat := range other {
+		k[char] = k[char].Add(stat)
+	}
+}
+
+// Per-character statistics for the keystrokes in the snapshot
+// The latency of a keystroke is the time since the one before it, so the
+// first keystroke of a session only counts towards hits and misses.
+func (s Snapshot) KeyStats() KeyStats {
+	stats := make(KeyStats)
+
+	for i, k := range s.Keystrokes {
+		stat := stats[k.Expected]
+		if k.Correct() {
+			stat.Hits++
+		} else {
+			stat.Misses++
+		}
+		if i > 0 {
+			stat.Latency += k.Time.Sub(s.Keystrokes[i-1].Time)
+			stat.Timed++
+		}
+		stats[k.Expected] = stat
+	}
+
+	return stats
+}
+
+// Statistics for pairs of consecutive characters in the snapshot
+// A bigram like "th" counts whether "h" was typed right after reaching it
+// from "t", and how long that took. Keystrokes that don't follow on from the
+// previous position (after a Backspace) don't form a bigram.
+func (s Snapshot) BigramStats() KeyStats {
+	stats := make(KeyStats)
+
+	for i, k := range s.Keystrokes {
+		if i == 0 || k.Position == 0 || s.Keystrokes[i-1].Position != k.Position-1 {
+			continue
+		}
+
+		bigram := s.Keystrokes[i-1].Expected + k.Expected
+		stat := stats[bigram]
+		if k.Correct() {
+			stat.Hits++
+		} else {
+			stat.Misses++
+		}
+		stat.Latency += k.Time.Sub(s.Keystrokes[i-1].Time)
+		stat.Timed++
+		stats[bigram] = stat
+	}
+
+	return stats
+}
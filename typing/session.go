@@ -0,0 +1,308 @@
+// Package typing is the typing engine behind typr2
+// A Session compares what has been typed against a target text and keeps
+// track of mistakes and timing, without knowing anything about how keys are
+// read or how the result is displayed.
+//
+// Text is handled as grapheme clusters, what a reader sees as a single
+// character: "é" is one whether it's written as one code point or as "e"
+// with a combining accent, and so is an emoji made of several.
+package typing
+
+import (
+	"strings"
+	"time"
+
+	"github.com/rivo/uniseg"
+	"golang.org/x/text/unicode/norm"
+)
+
+// State of a single character of the target text
+type State int
+
+const (
+	Untyped   State = iota // Not reached yet
+	Correct                // Typed correctly the first time
+	Incorrect              // Currently typed wrong
+	Corrected              // Typed wrong at some point, now correct
+)
+
+// How a session deals with mistakes
+type ErrorMode int
+
+const (
+	ErrorFree   ErrorMode = iota // Mistakes move on like anything else, and can be fixed with Backspace
+	StopOnError                  // The cursor doesn't move until the right character is typed
+	StopOnWord                   // Mistakes move on, but a word has to be fixed before the space after it
+	Strict                       // No Backspace, mistakes stay and the session ends at the end of the target
+)
+
+var errorModeNames = []string{"free", "stop-on-error", "stop-on-word", "strict"}
+
+func (e ErrorMode) String() string {
+	return errorModeNames[e]
+}
+
+// Parse an error mode by name, as returned by String
+func ParseErrorMode(name string) (ErrorMode, bool) {
+	for i, n := range errorModeNames {
+		if n == name {
+			return ErrorMode(i), true
+		}
+	}
+	return ErrorFree, false
+}
+
+// A single key press recorded by the session
+type Keystroke struct {
+	Time     time.Time
+	Position int    // Index into the target text
+	Text     string // What was typed
+	Expected string // What should have been typed
+}
+
+// Whether the keystroke matched the target text
+func (k Keystroke) Correct() bool {
+	return k.Text == k.Expected
+}
+
+// Split text into grapheme clusters, normalized so the same character
+// always compares equal however it was composed
+func Graphemes(text string) []string {
+	var graphemes []string
+	state := -1
+	text = norm.NFC.String(text)
+	for text != "" {
+		var grapheme string
+		grapheme, text, _, state = uniseg.FirstGraphemeClusterInString(text, state)
+		graphemes = append(graphemes, grapheme)
+	}
+	return graphemes
+}
+
+// A typing session for a single target text
+type Session struct {
+	target     []string // Grapheme clusters
+	input      []string
+	states     []State
+	mistyped   []bool // Positions that were typed wrong at least once
+	keystrokes []Keystroke
+	start      time.Time
+	end        time.Time
+	free       bool // No target, whatever is typed is right
+	finished   bool // Ended before the target was completed
+	errorMode  ErrorMode
+}
+
+// Create a new session for the given target text
+func NewSession(target string) *Session {
+	graphemes := Graphemes(target)
+
+	return &Session{
+		target:   graphemes,
+		states:   make([]State, len(graphemes)),
+		mistyped: make([]bool, len(graphemes)),
+	}
+}
+
+// Create a session without a target text, for typing freely
+// What's typed becomes the target, and the session only ends with Finish.
+func NewFreeSession() *Session {
+	return &Session{free: true}
+}
+
+// Change how the session deals with mistakes from now on
+func (s *Session) SetErrorMode(mode ErrorMode) {
+	s.errorMode = mode
+}
+
+// Add more text to the end of the target, e.g. to keep a timed test going
+func (s *Session) Append(text string) {
+	graphemes := Graphemes(text)
+	s.target = append(s.target, graphemes...)
+	s.states = append(s.states, make([]State, len(graphemes))...)
+	s.mistyped = append(s.mistyped, make([]bool, len(graphemes))...)
+}
+
+// End the session at time t, whether the target was completed or not
+// Only what was typed until then counts.
+func (s *Session) Finish(t time.Time) {
+	if s.Done() {
+		return
+	}
+	s.finished = true
+	if !s.start.IsZero() {
+		s.end = t
+	}
+}
+
+// Type text at time t, one grapheme cluster at a time
+// A single key event can carry several, e.g. from an input method or a
+// paste.
+func (s *Session) Type(text string, t time.Time) {
+	for _, grapheme := range Graphemes(text) {
+		s.typeGrapheme(grapheme, t)
+	}
+}
+
+// Type a single grapheme cluster
+// Input past the end of the target is ignored, since there's nothing to
+// compare it to.
+func (s *Session) typeGrapheme(g string, t time.Time) {
+	if s.finished {
+		return
+	}
+	if s.free {
+		s.Append(g)
+	}
+
+	pos := len(s.input)
+	if pos >= len(s.target) {
+		return
+	}
+
+	if s.start.IsZero() {
+		s.start = t
+	}
+
+	expected := s.target[pos]
+
+	// Nothing gets past the end of a word with mistakes in it, not even
+	// recorded as a keystroke
+	if s.errorMode == StopOnWord && expected == " " && s.wordHasErrors(pos) {
+		return
+	}
+
+	s.keystrokes = append(s.keystrokes, Keystroke{
+		Time:     t,
+		Position: pos,
+		Text:     g,
+		Expected: expected,
+	})
+
+	// The mistake counts, but the cursor stays where it is
+	if s.errorMode == StopOnError && g != expected {
+		s.states[pos] = Incorrect
+		s.mistyped[pos] = true
+		return
+	}
+
+	s.input = append(s.input, g)
+	switch {
+	case g != expected:
+		s.states[pos] = Incorrect
+		s.mistyped[pos] = true
+	case s.mistyped[pos]:
+		s.states[pos] = Corrected
+	default:
+		s.states[pos] = Correct
+	}
+
+	if s.Done() {
+		s.end = t
+	}
+}
+
+// Whether the word before position pos has any mistakes left in it
+func (s *Session) wordHasErrors(pos int) bool {
+	for i := pos - 1; i >= 0 && s.target[i] != " "; i-- {
+		if s.states[i] == Incorrect {
+			return true
+		}
+	}
+	return false
+}
+
+// Remove the last typed character
+// Strict sessions don't allow it. When stopping on errors, a mistake that
+// didn't move the cursor is all that's removed.
+func (s *Session) Backspace() {
+	if s.finished || s.errorMode == Strict {
+		return
+	}
+	if cursor := len(s.input); cursor < len(s.states) && s.states[cursor] == Incorrect {
+		s.states[cursor] = Untyped
+		return
+	}
+	if len(s.input) == 0 {
+		return
+	}
+
+	pos := len(s.input) - 1
+	s.input = s.input[:pos]
+	s.states[pos] = Untyped
+	s.end = time.Time{}
+
+	// Without a target there's nothing left to type there
+	if s.free {
+		s.target, s.states, s.mistyped = s.target[:pos], s.states[:pos], s.mistyped[:pos]
+	}
+}
+
+// Whether the whole target has been typed correctly (or at all in strict
+// sessions, where mistakes can't be fixed), or the session was finished
+// early
+func (s *Session) Done() bool {
+	if s.finished {
+		return true
+	}
+	if s.free || len(s.input) != len(s.target) {
+		return false
+	}
+	if s.errorMode == Strict {
+		return true
+	}
+
+	for _, state := range s.states {
+		if state == Incorrect {
+			return false
+		}
+	}
+
+	return true
+}
+
+// The target text of the session
+func (s *Session) Target() string {
+	return strings.Join(s.target, "")
+}
+
+// A read-only view of a session at some point in time
+type Snapshot struct {
+	Target     []string // Grapheme clusters
+	Input      []string
+	States     []State
+	Cursor     int   // Index of the next grapheme to type
+	Errors     []int // Positions that were typed wrong at least once
+	Keystrokes []Keystroke
+	Start      time.Time // Time of the first keystroke
+	End        time.Time // Time the session was completed
+	Done       bool
+	Free       bool // Typed without a target text
+	ErrorMode  ErrorMode
+}
+
+// Take a snapshot of the session
+// The snapshot doesn't share any memory with the session, so it's safe to
+// keep around (or hand to another goroutine) while typing continues.
+func (s *Session) Snapshot() Snapshot {
+	var errors []int
+	for i, mistyped := range s.mistyped {
+		if mistyped {
+			errors = append(errors, i)
+		}
+	}
+
+	return Snapshot{
+		Target:     append([]string(nil), s.target...),
+		Input:      append([]string(nil), s.input...),
+		States:     append([]State(nil), s.states...),
+		Cursor:     len(s.input),
+		Errors:     errors,
+		Keystrokes: append([]Keystroke(nil), s.keystrokes...),
+		Start:      s.start,
+		End:        s.end,
+		Done:       s.Done(),
+		Free:       s.free,
+		ErrorMode:  s.errorMode,
+	}
+}
@@ -0,0 +1,263 @@
+package typing
+
+import (
+	"math"
+	"slices"
+	"testing"
+	"time"
+)
+
+var start = time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+// Type text a grapheme at a time, step apart, starting at t
+// Returns the time of the last keystroke.
+func typeText(s *Session, text string, t time.Time, step time.Duration) time.Time {
+	for i, g := range Graphemes(text) {
+		if i > 0 {
+			t = t.Add(step)
+		}
+		s.Type(g, t)
+	}
+	return t
+}
+
+func TestErrorFree(t *testing.T) {
+	s := NewSession("ab")
+	s.Type("x", start)
+	s.Type("b", start)
+
+	snapshot := s.Snapshot()
+	if want := []State{Incorrect, Correct}; !slices.Equal(snapshot.States, want) {
+		t.Errorf("States = %v, want %v", snapshot.States, want)
+	}
+	if snapshot.Cursor != 2 || snapshot.Done {
+		t.Errorf("Cursor = %d, Done = %v, want 2 and false", snapshot.Cursor, snapshot.Done)
+	}
+
+	s.Backspace()
+	s.Backspace()
+	s.Type("ab", start)
+	snapshot = s.Snapshot()
+	if want := []State{Corrected, Correct}; !slices.Equal(snapshot.States, want) {
+		t.Errorf("States after fixing = %v, want %v", snapshot.States, want)
+	}
+	if !snapshot.Done {
+		t.Error("not done after fixing the mistake")
+	}
+}
+
+func TestStopOnError(t *testing.T) {
+	s := NewSession("ab")
+	s.SetErrorMode(StopOnError)
+
+	s.Type("x", start)
+	snapshot := s.Snapshot()
+	if snapshot.Cursor != 0 || snapshot.States[0] != Incorrect || len(snapshot.Keystrokes) != 1 {
+		t.Errorf("after a mistake: Cursor = %d, States[0] = %v, %d keystrokes, want 0, Incorrect, 1",
+			snapshot.Cursor, snapshot.States[0], len(snapshot.Keystrokes))
+	}
+
+	s.Type("a", start)
+	snapshot = s.Snapshot()
+	if snapshot.Cursor != 1 || snapshot.States[0] != Corrected {
+		t.Errorf("after the right key: Cursor = %d, States[0] = %v, want 1, Corrected", snapshot.Cursor, snapshot.States[0])
+	}
+
+	// Backspace only clears the pending mistake
+	s.Type("x", start)
+	s.Backspace()
+	snapshot = s.Snapshot()
+	if snapshot.Cursor != 1 || snapshot.States[1] != Untyped {
+		t.Errorf("after Backspace: Cursor = %d, States[1] = %v, want 1, Untyped", snapshot.Cursor, snapshot.States[1])
+	}
+}
+
+func TestStopOnWord(t *testing.T) {
+	s := NewSession("ab cd")
+	s.SetErrorMode(StopOnWord)
+
+	s.Type("xb ", start)
+	snapshot := s.Snapshot()
+	if snapshot.Cursor != 2 || len(snapshot.Keystrokes) != 2 {
+		t.Errorf("space after a mistake: Cursor = %d, %d keystrokes, want 2 and 2", snapshot.Cursor, len(snapshot.Keystrokes))
+	}
+
+	s.Backspace()
+	s.Backspace()
+	s.Type("ab ", start)
+	if cursor := s.Snapshot().Cursor; cursor != 3 {
+		t.Errorf("space after fixing the word: Cursor = %d, want 3", cursor)
+	}
+}
+
+func TestStrict(t *testing.T) {
+	s := NewSession("ab")
+	s.SetErrorMode(Strict)
+
+	s.Type("x", start)
+	s.Backspace()
+	if cursor := s.Snapshot().Cursor; cursor != 1 {
+		t.Errorf("Backspace moved the cursor to %d", cursor)
+	}
+
+	s.Type("b", start)
+	snapshot := s.Snapshot()
+	if !snapshot.Done {
+		t.Error("not done at the end of the target")
+	}
+	if snapshot.States[0] != Incorrect {
+		t.Errorf("States[0] = %v, want the mistake kept", snapshot.States[0])
+	}
+}
+
+func TestBackspaceAcrossWords(t *testing.T) {
+	s := NewSession("ab cd")
+	s.Type("ab c", start)
+	s.Backspace()
+	s.Backspace()
+	s.Backspace()
+
+	snapshot := s.Snapshot()
+	if snapshot.Cursor != 1 {
+		t.Errorf("Cursor = %d, want 1", snapshot.Cursor)
+	}
+	if want := []State{Correct, Untyped, Untyped, Untyped, Untyped}; !slices.Equal(snapshot.States, want) {
+		t.Errorf("States = %v, want %v", snapshot.States, want)
+	}
+
+	s.Type("b cd", start)
+	if !s.Done() {
+		t.Error("not done after typing the rest")
+	}
+}
+
+func TestGraphemes(t *testing.T) {
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{"abc", []string{"a", "b", "c"}},
+		{"é", []string{"é"}},              // Composed to a single code point
+		{"👨‍👩‍👧!", []string{"👨‍👩‍👧", "!"}}, // One emoji made of several code points
+		{"", nil},
+	}
+	for _, test := range tests {
+		if got := Graphemes(test.text); !slices.Equal(got, test.want) {
+			t.Errorf("Graphemes(%q) = %q, want %q", test.text, got, test.want)
+		}
+	}
+}
+
+func TestTypeDecomposed(t *testing.T) {
+	s := NewSession("café")
+	s.Type("café", start)
+
+	snapshot := s.Snapshot()
+	if !snapshot.Done || len(snapshot.Keystrokes) != 4 {
+		t.Errorf("Done = %v with %d keystrokes, want true with 4", snapshot.Done, len(snapshot.Keystrokes))
+	}
+}
+
+func TestFinish(t *testing.T) {
+	s := NewSession("abc")
+	s.Type("a", start)
+	s.Finish(start.Add(time.Second))
+	s.Type("bc", start.Add(2*time.Second))
+
+	snapshot := s.Snapshot()
+	if !snapshot.Done || snapshot.Cursor != 1 {
+		t.Errorf("Done = %v, Cursor = %d, want true and 1", snapshot.Done, snapshot.Cursor)
+	}
+	if want := start.Add(time.Second); !snapshot.End.Equal(want) {
+		t.Errorf("End = %v, want %v", snapshot.End, want)
+	}
+
+	// Nothing typed, nothing timed
+	s = NewSession("abc")
+	s.Finish(start)
+	if snapshot := s.Snapshot(); !snapshot.Done || !snapshot.End.IsZero() {
+		t.Errorf("Done = %v, End = %v, want true and zero", snapshot.Done, snapshot.End)
+	}
+}
+
+func TestFreeSession(t *testing.T) {
+	s := NewFreeSession()
+	s.Type("hi", start)
+	s.Backspace()
+
+	if s.Target() != "h" || s.Done() {
+		t.Errorf("Target = %q, Done = %v, want \"h\" and false", s.Target(), s.Done())
+	}
+	s.Finish(start)
+	if !s.Done() {
+		t.Error("not done after Finish")
+	}
+}
+
+func TestStats(t *testing.T) {
+	// 10 characters a minute is 2 WPM, and one still wrong takes one off
+	s := NewSession("abcdefghij")
+	typeText(s, "abxdefghij", start, time.Minute/9)
+	stats := s.Snapshot().Stats(start.Add(time.Minute))
+
+	if math.Abs(stats.GrossWPM-2) > 0.01 || math.Abs(stats.NetWPM-1) > 0.01 {
+		t.Errorf("GrossWPM = %.2f, NetWPM = %.2f, want 2 and 1", stats.GrossWPM, stats.NetWPM)
+	}
+	if stats.Accuracy != 90 || stats.Errors != 1 || stats.Uncorrected != 1 {
+		t.Errorf("Accuracy = %.1f, Errors = %d, Uncorrected = %d, want 90, 1, 1", stats.Accuracy, stats.Errors, stats.Uncorrected)
+	}
+	if math.Abs(stats.Consistency-100) > 0.01 {
+		t.Errorf("Consistency = %.2f, want 100 for an even rhythm", stats.Consistency)
+	}
+
+	// A fixed mistake still costs accuracy, but not speed
+	s = NewSession("abcde")
+	s.Type("x", start)
+	s.Backspace()
+	end := typeText(s, "abcde", start.Add(time.Second), time.Second)
+	stats = s.Snapshot().Stats(end.Add(time.Hour))
+
+	if stats.Keystrokes != 6 || stats.Uncorrected != 0 || math.Abs(stats.Accuracy-500.0/6) > 0.01 {
+		t.Errorf("Keystrokes = %d, Uncorrected = %d, Accuracy = %.2f, want 6, 0, 83.33", stats.Keystrokes, stats.Uncorrected, stats.Accuracy)
+	}
+	if stats.Duration != 5*time.Second || stats.GrossWPM != stats.NetWPM {
+		t.Errorf("Duration = %v, GrossWPM = %.2f, NetWPM = %.2f, want 5s and both the same", stats.Duration, stats.GrossWPM, stats.NetWPM)
+	}
+}
+
+func TestKeyStats(t *testing.T) {
+	s := NewSession("aab")
+	s.Type("a", start)
+	s.Type("x", start.Add(100*time.Millisecond))
+	s.Backspace()
+	s.Type("a", start.Add(300*time.Millisecond))
+	s.Type("b", start.Add(400*time.Millisecond))
+	snapshot := s.Snapshot()
+
+	keys := snapshot.KeyStats()
+	want := KeyStats{
+		"a": {Hits: 2, Misses: 1, Latency: 300 * time.Millisecond, Timed: 2},
+		"b": {Hits: 1, Latency: 100 * time.Millisecond, Timed: 1},
+	}
+	for char, stat := range want {
+		if keys[char] != stat {
+			t.Errorf("KeyStats[%q] = %+v, want %+v", char, keys[char], stat)
+		}
+	}
+
+	// The retyped "a" doesn't follow on from the mistake, so only the
+	// mistake and the "b" after the retyped "a" make bigrams
+	bigrams := snapshot.BigramStats()
+	want = KeyStats{
+		"aa": {Misses: 1, Latency: 100 * time.Millisecond, Timed: 1},
+		"ab": {Hits: 1, Latency: 100 * time.Millisecond, Timed: 1},
+	}
+	if len(bigrams) != len(want) {
+		t.Errorf("BigramStats = %+v, want %+v", bigrams, want)
+	}
+	for bigram, stat := range want {
+		if bigrams[bigram] != stat {
+			t.Errorf("BigramStats[%q] = %+v, want %+v", bigram, bigrams[bigram], stat)
+		}
+	}
+}
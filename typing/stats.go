@@ -0,0 +1,97 @@
+package typing
+
+import (
+	"math"
+	"time"
+)
+
+// A "word" is standardized as 5 characters when calculating WPM
+const charsPerWord = 5.0
+
+// Typing statistics for a session
+type Stats struct {
+	Duration    time.Duration
+	Characters  int     // Characters typed so far
+	Keystrokes  int     // Every key press, including mistakes that were fixed
+	Errors      int     // Key presses that didn't match the target
+	Uncorrected int     // Characters that are still wrong
+	GrossWPM    float64 // Speed counting every typed character
+	NetWPM      float64 // Speed with a penalty for uncorrected errors
+	Accuracy    float64 // Percentage of key presses that were correct
+	Consistency float64 // Percentage, 100 means a perfectly even rhythm
+}
+
+// Calculate statistics as of time now
+// Finished sessions are measured up to their completion time instead.
+func (s Snapshot) Stats(now time.Time) Stats {
+	stats := Stats{
+		Characters: s.Cursor,
+		Keystrokes: len(s.Keystrokes),
+	}
+
+	for _, k := range s.Keystrokes {
+		if !k.Correct() {
+			stats.Errors++
+		}
+	}
+	for _, state := range s.States {
+		if state == Incorrect {
+			stats.Uncorrected++
+		}
+	}
+
+	if stats.Keystrokes > 0 {
+		stats.Accuracy = 100 * float64(stats.Keystrokes-stats.Errors) / float64(stats.Keystrokes)
+	}
+
+	if s.Start.IsZero() {
+		return stats
+	}
+
+	end := now
+	if !s.End.IsZero() {
+		end = s.End
+	}
+	stats.Duration = end.Sub(s.Start)
+
+	minutes := stats.Duration.Minutes()
+	if minutes > 0 {
+		stats.GrossWPM = float64(stats.Characters) / charsPerWord / minutes
+		stats.NetWPM = math.Max(stats.GrossWPM-float64(stats.Uncorrected)/minutes, 0)
+	}
+
+	stats.Consistency = consistency(s.Keystrokes)
+
+	return stats
+}
+
+// Measure how even the time between keystrokes is, as 100% minus the
+// coefficient of variation of the intervals
+func consistency(keystrokes []Keystroke) float64 {
+	if len(keystrokes) < 3 {
+		return 0
+	}
+
+	intervals := make([]float64, 0, len(keystrokes)-1)
+	for i := 1; i < len(keystrokes); i++ {
+		intervals = append(intervals, keystrokes[i].Time.Sub(keystrokes[i-1].Time).Seconds())
+	}
+
+	mean := 0.0
+	for _, interval := range intervals {
+		mean += interval
+	}
+	mean /= float64(len(intervals))
+	if mean <= 0 {
+		return 0
+	}
+
+	variance := 0.0
+	for _, interval := range intervals {
+		variance += (interval - mean) * (interval - mean)
+	}
+	variance /= float64(len(intervals))
+
+	cv := math.Sqrt(variance) / mean
+	return math.Max(0, 100*(1-cv))
+}
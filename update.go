@@ -3,8 +3,9 @@ package main
 import (
 	"fmt"
 	"log"
-	"strings"
+	"maps"
 	"time"
+	"typr2/typing"
 
 	tea "github.com/charmbracelet/bubbletea"
 )
@@ -47,8 +48,13 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			return m.handleCommandMode(msg)
 		}
 
+		// Any key dismisses the result of the last command
+		m.commandError = ""
+		escaped := m.escaped
+		m.escaped = false
+
 		// Global navigation keys (only in normal mode)
-		switch msg.String() {
+		switch key := msg.String(); key {
 		case "ctrl+c":
 			log.Println("Quitting...")
 			return m, tea.Quit
@@ -57,16 +63,20 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				log.Println("Quitting...")
 				return m, tea.Quit
 			}
-		case m.config.CommandKey:
-			m.commandMode = CommandModeActive
-			m.commandInput = ""
-			m.commandError = ""
-			return m, nil
-		case m.config.SearchKey:
-			m.commandMode = SearchModeActive
-			m.commandInput = ""
-			m.commandError = ""
-			return m, nil
+		case "esc":
+			if m.currentScreen == MainScreen {
+				m.escaped = true
+				return m, nil
+			}
+		case m.config.CommandKey, m.config.SearchKey:
+			if escaped || !m.typesKey(key) {
+				m.commandMode = CommandModeActive
+				if key == m.config.SearchKey {
+					m.commandMode = SearchModeActive
+				}
+				m.commandInput = ""
+				return m, nil
+			}
 		}
 
 		// Screen-specific navigation
@@ -79,48 +89,95 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			return m.handleConfigScreen(msg)
 		case ExtrasScreen:
 			return m.handleExtrasScreen(msg)
+		case ResultsScreen:
+			return m.handleResultsScreen(msg)
 		}
 
 	case ScreenChangeMsg:
 		log.Printf("change screen from [ %v ] to [ %v ]", m.currentScreen, msg.screen)
 		m.currentScreen = msg.screen
 		return m, nil
+
+	case KeyReleaseMsg:
+		return m.releaseKey(msg), nil
+
+	case TestTimerMsg:
+		return m.handleTestTimer(msg)
+
+	case AdvancePromptMsg:
+		// Ignore it if the prompt already changed (e.g. with tab)
+		if msg.id == m.promptID {
+			m = m.nextPrompt()
+		}
+		return m, nil
 	}
 
 	return m, nil
 }
 
+// Whether a key is typed rather than opening command or search mode
+// Prompts can have the command and search keys in them, so on the typing
+// screen they're typed once a prompt is under way, or when the prompt
+// starts with them. Esc first opens the mode anyway.
+func (m Model) typesKey(key string) bool {
+	if m.currentScreen != MainScreen {
+		return false
+	}
+	snapshot := m.session.Snapshot()
+	switch {
+	case snapshot.Done:
+		return false
+	case !snapshot.Start.IsZero():
+		return true
+	}
+	return len(snapshot.Target) > 0 && snapshot.Target[0] == key
+}
+
 // Handle start screen input
+// The menu lists the lessons, followed by the other screens.
 func (m Model) handleStartScreen(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	items := len(m.lessons) + 2
+
 	switch msg.String() {
 	case "up", "k":
 		if m.menuSelection > 0 {
 			m.menuSelection--
 		}
 	case "down", "j":
-		if m.menuSelection < 2 { // 3 menu items (0-2)
+		if m.menuSelection < items-1 {
 			m.menuSelection++
 		}
 	case "enter", " ":
-		switch m.menuSelection {
-		case 0:
-			return m, func() tea.Msg { return ScreenChangeMsg{MainScreen} }
-		case 1:
-			return m, func() tea.Msg { return ScreenChangeMsg{ConfigScreen} }
-		case 2:
-			return m, func() tea.Msg { return ScreenChangeMsg{ExtrasScreen} }
+		return m.selectMenuItem(m.menuSelection)
+	case "1", "2", "3", "4", "5", "6", "7", "8", "9":
+		if i := int(msg.String()[0] - '1'); i < items {
+			m.menuSelection = i
+			return m.selectMenuItem(i)
 		}
-	case "1":
-		m.menuSelection = 0
-		return m, func() tea.Msg { return ScreenChangeMsg{MainScreen} }
-	case "2":
-		m.menuSelection = 1
+	}
+	return m, nil
+}
+
+// Open a start screen menu item: a lesson, the config screen or extras
+func (m Model) selectMenuItem(item int) (tea.Model, tea.Cmd) {
+	switch item {
+	case len(m.lessons):
 		return m, func() tea.Msg { return ScreenChangeMsg{ConfigScreen} }
-	case "3":
-		m.menuSelection = 2
+	case len(m.lessons) + 1:
+		// The keyboard may have changed since last time
+		m.layoutAnalysis = formatAnalysis(m.keyboard, analysisLayouts(), analysisCorpus())
 		return m, func() tea.Msg { return ScreenChangeMsg{ExtrasScreen} }
 	}
-	return m, nil
+
+	if !m.lessonUnlocked(item) {
+		m.commandError = m.lockedReason(item)
+		return m, nil
+	}
+
+	if item != m.lesson || !m.lessonActive {
+		m = m.startLesson(item)
+	}
+	return m, func() tea.Msg { return ScreenChangeMsg{MainScreen} }
 }
 
 // Handle main screen input
@@ -135,62 +192,164 @@ func (m Model) handleMainScreen(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 
 	case "tab":
 		// Next prompt
-		m.promptIndex = (m.promptIndex + 1) % len(m.prompts)
-		m.prompt = m.prompts[m.promptIndex]
-		m.userInput = ""
-		m.currentChar = 0
-		m.pressedKeys = make(map[string]bool)
+		m = m.nextPrompt()
 
 	case "backspace":
-		if len(m.userInput) > 0 {
-			m.userInput = m.userInput[:len(m.userInput)-1]
-			if m.currentChar > 0 {
-				m.currentChar--
-			}
+		if m.timeUp(time.Now()) {
+			return m.endTimedTest()
+		}
+		m.session.Backspace()
+
+	case "enter":
+		// Zen tests go on until they're ended
+		if m.mode.Kind == modeZen && !m.session.Done() && m.session.Snapshot().Cursor > 0 {
+			m.session.Finish(time.Now())
+			return m.completeSession()
 		}
 
 	default:
-		// Handle regular character input
-		char := msg.String()
-		if len(char) == 1 || char == "space" {
-			if char == "space" {
-				char = " "
+		// Handle regular character input, which can be more than one
+		// character at once from an input method or a paste
+		if (msg.Type == tea.KeyRunes || msg.Type == tea.KeySpace) && !msg.Alt {
+			// Keystrokes after the time is up don't count, even if the
+			// clock hasn't ticked yet
+			now := time.Now()
+			if m.timeUp(now) {
+				return m.endTimedTest()
 			}
+			text := string(msg.Runes)
 
-			// Simulate key press
-			keyLabel := strings.ToUpper(char)
-			if char == " " {
-				keyLabel = "SPACE"
+			// Simulate the key presses, including Shift for shifted characters
+			var cmds []tea.Cmd
+			for _, g := range typing.Graphemes(text) {
+				for _, key := range m.keyboard.KeysForGrapheme(g) {
+					var cmd tea.Cmd
+					m, cmd = m.pressKey(key)
+					cmds = append(cmds, cmd)
+				}
 			}
-			m.pressedKeys[keyLabel] = true
 
-			// Clear pressed keys after a short delay
-			go func() {
-				time.Sleep(100 * time.Millisecond)
-				delete(m.pressedKeys, keyLabel)
-			}()
+			started := !m.session.Snapshot().Start.IsZero()
+			m.session.Type(text, now)
 
-			m.userInput += char
-			if m.currentChar < len(m.prompt) {
-				m.currentChar++
+			// Timed tests start the clock with the first keystroke, and
+			// stream more words as they go
+			if m.mode.Kind == modeTime && !m.session.Done() {
+				m.streamWords(m.session)
+				if !started {
+					cmds = append(cmds, m.tickTestTimer(m.session.Snapshot()))
+				}
 			}
 
 			// Check if prompt is completed
-			if m.userInput == m.prompt {
-				// Auto advance to next prompt after completion
-				time.AfterFunc(1*time.Second, func() {
-					m.promptIndex = (m.promptIndex + 1) % len(m.prompts)
-					m.prompt = m.prompts[m.promptIndex]
-					m.userInput = ""
-					m.currentChar = 0
-					m.pressedKeys = make(map[string]bool)
-				})
+			if m.session.Done() {
+				var cmd tea.Cmd
+				m, cmd = m.completeSession()
+				cmds = append(cmds, cmd)
 			}
+
+			return m, tea.Batch(cmds...)
 		}
 	}
 	return m, nil
 }
 
+// Record a completed session, then move on: to the results screen after a
+// test, or to the next prompt after a while if auto advance is on
+func (m Model) completeSession() (Model, tea.Cmd) {
+	if m.recorded {
+		return m, nil
+	}
+	m.recorded = true
+
+	entry := m.newHistoryEntry(m.session.Snapshot())
+	m.keyStats.Merge(entry.KeyStats)
+	m.bigramStats.Merge(entry.BigramStats)
+	cmds := []tea.Cmd{m.saveHistory(entry)}
+
+	// Lessons are passed on their own prompts, not in tests
+	if m.lessonActive && m.mode.Kind == modePrompt && m.lessons[m.lesson].Pass.Passed(entry.NetWPM, entry.Accuracy) {
+		var message string
+		if m, message = m.passLesson(); message != "" {
+			m.commandError = message
+		}
+	}
+
+	switch {
+	case m.mode.Kind != modePrompt:
+		cmds = append(cmds, func() tea.Msg { return ScreenChangeMsg{ResultsScreen} })
+	case m.config.Typing.AutoAdvance:
+		delay := time.Duration(m.config.Typing.AdvanceDelay) * time.Millisecond
+		id := m.promptID
+		cmds = append(cmds, tea.Tick(delay, func(time.Time) tea.Msg {
+			return AdvancePromptMsg{id: id}
+		}))
+	}
+
+	return m, tea.Batch(cmds...)
+}
+
+// How long a key stays highlighted on the onscreen keyboard after a press
+const keyFlashDuration = 100 * time.Millisecond
+
+// Highlight a key on the onscreen keyboard, and schedule its release
+// Every press gets a new ID, so a release scheduled by an earlier press of
+// the same key doesn't cut the highlight of a later one short.
+func (m Model) pressKey(key int) (Model, tea.Cmd) {
+	m.keyPressID++
+	id := m.keyPressID
+
+	// Copy on write, so models handed out earlier never see the change
+	pressed := maps.Clone(m.pressedKeys)
+	pressed[key] = id
+	m.pressedKeys = pressed
+
+	return m, tea.Tick(keyFlashDuration, func(time.Time) tea.Msg {
+		return KeyReleaseMsg{key: key, id: id}
+	})
+}
+
+// Handle the release of a highlighted key
+func (m Model) releaseKey(msg KeyReleaseMsg) Model {
+	if m.pressedKeys[msg.key] != msg.id {
+		// Pressed again since, that press will release it
+		return m
+	}
+
+	pressed := maps.Clone(m.pressedKeys)
+	delete(pressed, msg.key)
+	m.pressedKeys = pressed
+	return m
+}
+
+// Move on to the next prompt from the source with a fresh typing session
+func (m Model) nextPrompt() Model {
+	m.promptNumber++
+	m.promptID++
+	m.session = m.newSession()
+	m.recorded = false
+	m.pressedKeys = make(map[int]int)
+	return m
+}
+
+// Switch to a lesson, starting at its first prompt
+func (m Model) startLesson(lesson int) Model {
+	m.lesson = lesson
+	m = m.startSource(m.lessonSource(m.lessons[lesson]), "Lesson: "+m.lessons[lesson].Title)
+	m.lessonActive = true
+	return m
+}
+
+// Switch to another prompt source, starting at its first prompt
+// The current lesson is put aside until it's started again.
+func (m Model) startSource(source PromptSource, name string) Model {
+	m.source = source
+	m.sourceName = name
+	m.lessonActive = false
+	m.promptNumber = 0
+	return m.nextPrompt()
+}
+
 // Handle config screen input
 func (m Model) handleConfigScreen(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 	switch msg.String() {
@@ -200,6 +359,18 @@ func (m Model) handleConfigScreen(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 	return m, nil
 }
 
+// Handle results screen input, after a test
+func (m Model) handleResultsScreen(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.String() {
+	case "tab", "enter":
+		m = m.nextPrompt()
+		return m, func() tea.Msg { return ScreenChangeMsg{MainScreen} }
+	case "esc", "b":
+		return m, func() tea.Msg { return ScreenChangeMsg{StartScreen} }
+	}
+	return m, nil
+}
+
 // Handle extras screen input
 func (m Model) handleExtrasScreen(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
 	switch msg.String() {
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"testing"
+	"typr2/typing"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+func TestStaleKeyReleaseKeepsHighlight(t *testing.T) {
+	m := Model{pressedKeys: make(map[int]int)}
+
+	m, cmd := m.pressKey(3)
+	first := cmd().(KeyReleaseMsg)
+	m, cmd = m.pressKey(3)
+	second := cmd().(KeyReleaseMsg)
+
+	m = m.releaseKey(first)
+	if _, ok := m.pressedKeys[3]; !ok {
+		t.Fatal("release of the first press cut the second one short")
+	}
+	m = m.releaseKey(second)
+	if _, ok := m.pressedKeys[3]; ok {
+		t.Error("key still highlighted after its last press was released")
+	}
+}
+
+func TestStaleAdvancePromptIgnored(t *testing.T) {
+	m := Model{
+		config:      DefaultConfig(),
+		lessons:     []Lesson{{ID: "test", Title: "Test", Prompts: []string{"one", "two", "three"}}},
+		pressedKeys: make(map[int]int),
+	}
+	m = m.startLesson(0)
+	stale := AdvancePromptMsg{id: m.promptID}
+
+	// Skipped ahead before the advance came in
+	m = m.nextPrompt()
+	next, _ := m.Update(stale)
+	m = next.(Model)
+
+	if target := m.session.Target(); target != "two" {
+		t.Errorf("prompt = %q after a stale advance, want \"two\"", target)
+	}
+
+	next, _ = m.Update(AdvancePromptMsg{id: m.promptID})
+	if target := next.(Model).session.Target(); target != "three" {
+		t.Errorf("prompt = %q after an advance, want \"three\"", target)
+	}
+}
+
+// A model on the typing screen, with nowhere to save anything
+func newTestModel() Model {
+	return Model{
+		currentScreen: MainScreen,
+		ready:         true,
+		config:        DefaultConfig(),
+		keyStats:      make(typing.KeyStats),
+		bigramStats:   make(typing.KeyStats),
+		passedLessons: make(map[string]bool),
+		pressedKeys:   make(map[int]int),
+	}
+}
+
+// Send a key for every character of s
+func typeKeys(m Model, s string) Model {
+	for _, r := range s {
+		msg := tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune{r}}
+		if r == ' ' {
+			msg.Type = tea.KeySpace
+		}
+		next, _ := m.Update(msg)
+		m = next.(Model)
+	}
+	return m
+}
+
+func TestTypeCommandKeys(t *testing.T) {
+	m := newTestModel()
+	m.lessons = []Lesson{{ID: "test", Title: "Test", Prompts: []string{"a: b/c", ":x"}}}
+	m = m.startLesson(0)
+
+	m = typeKeys(m, "a: b/c")
+	if !m.session.Done() || m.commandMode != NormalMode {
+		t.Fatalf("Done = %v, command mode = %v after typing a prompt with : and /", m.session.Done(), m.commandMode)
+	}
+
+	// Between prompts they open command and search mode
+	m = typeKeys(m, ":")
+	if m.commandMode != CommandModeActive {
+		t.Errorf("command mode = %v after : between prompts", m.commandMode)
+	}
+	next, _ := m.Update(tea.KeyMsg{Type: tea.KeyEsc})
+	m = next.(Model).nextPrompt()
+
+	// Unless the prompt starts with them, and after Esc they always do
+	m = typeKeys(m, ":")
+	if snapshot := m.session.Snapshot(); snapshot.Cursor != 1 || m.commandMode != NormalMode {
+		t.Errorf("Cursor = %d, command mode = %v after : starting a prompt", snapshot.Cursor, m.commandMode)
+	}
+	next, _ = m.Update(tea.KeyMsg{Type: tea.KeyEsc})
+	m = typeKeys(next.(Model), "/")
+	if m.commandMode != SearchModeActive || m.session.Snapshot().Cursor != 1 {
+		t.Errorf("command mode = %v, Cursor = %d after Esc /", m.commandMode, m.session.Snapshot().Cursor)
+	}
+}
+
+func TestTypeSymbolsLesson(t *testing.T) {
+	lessons, err := loadLessons("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := newTestModel()
+	m.lessons = lessons
+	m = m.startLesson(findLesson(lessons, "symbols"))
+
+	for range 5 {
+		prompt := m.session.Target()
+		m = typeKeys(m, prompt)
+		if !m.session.Done() || m.commandMode != NormalMode {
+			t.Fatalf("prompt %q not done after typing it", prompt)
+		}
+		m = m.nextPrompt()
+	}
+	if !m.passedLessons["symbols"] {
+		t.Error("symbols lesson not passed")
+	}
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/yosuke-furukawa/json5/encoding/json5"
+)
+
+// A VIA (or Vial) keyboard definition: KLE raw data with the matrix position
+// of every key as its top-left legend, and layout options that choose
+// between alternative keys with the bottom-right one
+// See: https://www.caniusevia.com/docs/layouts
+type viaDefinition struct {
+	Name   string `json:"name"`
+	Matrix struct {
+		Rows int `json:"rows"`
+		Cols int `json:"cols"`
+	} `json:"matrix"`
+	Layouts struct {
+		Labels []any `json:"labels"`
+		Keymap []any `json:"keymap"`
+	} `json:"layouts"`
+}
+
+// A layout option of a VIA definition, like split Backspace or ISO Enter
+type viaOption struct {
+	Name    string
+	Choices []string
+}
+
+// A keymap saved from VIA, with layers in matrix order row by row, or from
+// Vial, with layers of rows
+// Keycodes are QMK's, and Vial has -1 where there's no key.
+type viaKeymap struct {
+	Layers [][]any   `json:"layers"`
+	Layout [][][]any `json:"layout"`
+}
+
+// Layout options of a definition
+// Options with just a name are toggles, off or on.
+func (def viaDefinition) options() []viaOption {
+	var options []viaOption
+	for _, label := range def.Layouts.Labels {
+		switch v := label.(type) {
+		case string:
+			options = append(options, viaOption{Name: v, Choices: []string{"off", "on"}})
+		case []any:
+			var option viaOption
+			for i, s := range v {
+				name, _ := s.(string)
+				if i == 0 {
+					option.Name = name
+				} else {
+					option.Choices = append(option.Choices, name)
+				}
+			}
+			options = append(options, option)
+		}
+	}
+	return options
+}
+
+// Parse layout option choices like "1,0,2", one per option in order
+func parseLayoutOptions(s string) ([]int, error) {
+	var choices []int
+	for _, field := range strings.Split(s, ",") {
+		choice, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil || choice < 0 {
+			return nil, fmt.Errorf("layout options must be comma separated choices like 1,0, got %q", s)
+		}
+		choices = append(choices, choice)
+	}
+	return choices, nil
+}
+
+// Check layout option choices against the options a definition has
+func checkLayoutOptions(options []viaOption, choices []int) error {
+	if len(choices) > len(options) {
+		return fmt.Errorf("%d layout options given, but the keyboard has %d", len(choices), len(options))
+	}
+
+	var errs []error
+	for i, choice := range choices {
+		if choice >= len(options[i].Choices) {
+			var names []string
+			for c, name := range options[i].Choices {
+				names = append(names, fmt.Sprintf("%d (%s)", c, name))
+			}
+			errs = append(errs, fmt.Errorf("layout option %d (%s) must be one of %s, got %d", i, options[i].Name, strings.Join(names, ", "), choice))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// A pair of numbers in a legend, like the "0,13" of a matrix position or
+// the "1,2" of a layout option
+func parsePair(legend string) (int, int, bool) {
+	a, b, ok := strings.Cut(legend, ",")
+	if !ok {
+		return 0, 0, false
+	}
+	x, errX := strconv.Atoi(strings.TrimSpace(a))
+	y, errY := strconv.Atoi(strings.TrimSpace(b))
+	return x, y, errX == nil && errY == nil && x >= 0 && y >= 0
+}
+
+// The layout option and choice a key belongs to, if any
+func viaKeyOption(key Key) (option, choice int, ok bool) {
+	if len(key.Labels) <= 8 {
+		return 0, 0, false
+	}
+	return parsePair(key.Labels[8])
+}
+
+// Keep the keys of the chosen layout options, the first choice of any
+// option not given, and the keys that aren't part of any
+// Like VIA, the chosen keys are moved to where the first choice's keys are,
+// since alternatives are usually drawn off to the side.
+func selectLayoutOptions(keys []Key, choices []int) []Key {
+	type variant struct{ option, choice int }
+	origins := make(map[variant][2]float64)
+	for _, key := range keys {
+		option, choice, ok := viaKeyOption(key)
+		if !ok {
+			continue
+		}
+		v := variant{option, choice}
+		origin, seen := origins[v]
+		if !seen {
+			origin = [2]float64{key.X, key.Y}
+		}
+		origins[v] = [2]float64{min(origin[0], key.X), min(origin[1], key.Y)}
+	}
+
+	var selected []Key
+	for _, key := range keys {
+		option, choice, ok := viaKeyOption(key)
+		if ok {
+			chosen := 0
+			if option < len(choices) {
+				chosen = choices[option]
+			}
+			if choice != chosen {
+				continue
+			}
+
+			from, to := origins[variant{option, choice}], origins[variant{option, 0}]
+			dx, dy := to[0]-from[0], to[1]-from[1]
+			key.X, key.Y = key.X+dx, key.Y+dy
+			if key.RotationAngle != 0 {
+				key.RotationX, key.RotationY = key.RotationX+dx, key.RotationY+dy
+			}
+		}
+		selected = append(selected, key)
+	}
+	return selected
+}
+
+// Keycodes of a saved keymap, by layer and then matrix position (row by
+// row, cols to a row)
+func (k viaKeymap) keycodes(cols int) [][]string {
+	code := func(v any) string {
+		if s, ok := v.(string); ok {
+			return s
+		}
+		return "KC_NO"
+	}
+
+	var layers [][]string
+	for _, layer := range k.Layers {
+		var codes []string
+		for _, v := range layer {
+			codes = append(codes, code(v))
+		}
+		layers = append(layers, codes)
+	}
+	for _, layer := range k.Layout {
+		codes := make([]string, len(layer)*cols)
+		for row, keys := range layer {
+			for col, v := range keys[:min(len(keys), cols)] {
+				codes[row*cols+col] = code(v)
+			}
+		}
+		layers = append(layers, codes)
+	}
+	return layers
+}
+
+// Parse a VIA definition and a keymap saved from VIA or Vial
+// The definition only has matrix positions for legends, so what the keys
+// are comes from the keymap. Without one (nil keymapData) the keys are
+// blank and unidentified.
+func parseVIAKeyboard(data, keymapData []byte, choices []int) (Keyboard, error) {
+	var def viaDefinition
+	if err := json5.Unmarshal(data, &def); err != nil {
+		return Keyboard{}, fmt.Errorf("failed to parse VIA definition: %w", err)
+	}
+	var keymap viaKeymap
+	if keymapData != nil {
+		if err := json5.Unmarshal(keymapData, &keymap); err != nil {
+			return Keyboard{}, fmt.Errorf("failed to parse keymap: %w", err)
+		}
+	}
+	if err := checkLayoutOptions(def.options(), choices); err != nil {
+		return Keyboard{}, err
+	}
+
+	keyboard, err := parseKLEKeys(def.Layouts.Keymap)
+	if err != nil {
+		return Keyboard{}, err
+	}
+	keyboard.Meta.Name = cmp.Or(def.Name, keyboard.Meta.Name)
+	keyboard.Keys = selectLayoutOptions(keyboard.Keys, choices)
+
+	layers := keymap.keycodes(def.Matrix.Cols)
+	if keymapData != nil && len(layers) == 0 {
+		return Keyboard{}, errors.New("keymap has no layers")
+	}
+
+	var errs []error
+	for i := range keyboard.Keys {
+		key := &keyboard.Keys[i]
+		row, col, ok := parsePair(key.Labels[0])
+		if !ok {
+			// Decals and the like, not part of the matrix
+			continue
+		}
+		if row >= def.Matrix.Rows || col >= def.Matrix.Cols {
+			errs = append(errs, fmt.Errorf("key %d,%d is outside the %dx%d matrix", row, col, def.Matrix.Rows, def.Matrix.Cols))
+			continue
+		}
+		if len(layers) == 0 {
+			// Matrix positions make no sense as legends
+			key.Labels = make([]string, 12)
+			continue
+		}
+
+		codes := make([]string, len(layers))
+		for l, layer := range layers {
+			if pos := row*def.Matrix.Cols + col; pos < len(layer) {
+				codes[l] = layer[pos]
+			}
+		}
+		setQMKKeycodes(key, codes)
+	}
+	if err := errors.Join(errs...); err != nil {
+		return Keyboard{}, err
+	}
+
+	keyboard.Layers = qmkLayerNames(keyboard)
+	rememberPrinted(&keyboard)
+	assignFingers(&keyboard, nil)
+
+	return keyboard, nil
+}
+
+// Find the keymap saved for a VIA definition: the VIA backup or the Vial
+// file named after it, e.g. planck.layout.json or planck.vil for
+// planck.json. An empty path means there's none.
+func findVIAKeymap(filename string) string {
+	base := strings.TrimSuffix(filename, ".json")
+	for _, path := range []string{base + ".layout.json", base + ".vil"} {
+		if fileExists(path) {
+			return path
+		}
+	}
+	return ""
+}
+
+// Load a VIA definition, with the keymap given or the one saved next to it
+// Without a keymap there's still the shape of the keyboard, so it loads
+// with blank keys and a warning.
+func loadVIAKeyboard(filename string, data []byte, opts KeyboardOptions) (Keyboard, error) {
+	keymapFile := cmp.Or(opts.Keymap, findVIAKeymap(filename))
+	if keymapFile == "" {
+		keyboard, err := parseVIAKeyboard(data, nil, opts.LayoutOptions)
+		if err != nil {
+			return Keyboard{}, err
+		}
+		keyboard.Warning = fmt.Sprintf("VIA definitions don't say what the keys type, so they're blank: save the keymap from VIA or Vial as %s or give it with -keymap",
+			strings.TrimSuffix(filename, ".json")+".layout.json")
+		return keyboard, nil
+	}
+
+	keymapData, err := os.ReadFile(keymapFile)
+	if err != nil {
+		return Keyboard{}, fmt.Errorf("failed to read keymap: %w", err)
+	}
+	return parseVIAKeyboard(data, keymapData, opts.LayoutOptions)
+}
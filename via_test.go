@@ -0,0 +1,217 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// A key for the layout option tests, with its option and choice as its
+// bottom right legend
+func optionKey(x, y float64, option string) Key {
+	labels := make([]string, 12)
+	labels[0], labels[8] = "0,0", option
+	return Key{X: x, Y: y, Width: 1, Height: 1, Labels: labels}
+}
+
+func TestSelectLayoutOptions(t *testing.T) {
+	keys := []Key{
+		optionKey(0, 0, ""),    // Not part of any option
+		optionKey(1, 0, "0,0"), // Option 0, first choice: a 2u key
+		optionKey(5, 0, "0,1"), // Option 0, second choice: two 1u keys off to the side
+		optionKey(6, 0, "0,1"),
+		optionKey(0, 1, "1,0"), // Option 1, first choice
+		optionKey(0, 3, "1,1"), // Option 1, second choice, rotated
+	}
+	keys[1].Width = 2
+	keys[5].RotationAngle, keys[5].RotationX, keys[5].RotationY = 10, 0.5, 3.5
+
+	tests := []struct {
+		choices []int
+		want    [][2]float64 // Where the selected keys end up
+	}{
+		{nil, [][2]float64{{0, 0}, {1, 0}, {0, 1}}},
+		{[]int{1}, [][2]float64{{0, 0}, {1, 0}, {2, 0}, {0, 1}}},
+		{[]int{1, 1}, [][2]float64{{0, 0}, {1, 0}, {2, 0}, {0, 1}}},
+	}
+	for _, test := range tests {
+		var got [][2]float64
+		for _, key := range selectLayoutOptions(keys, test.choices) {
+			got = append(got, [2]float64{key.X, key.Y})
+		}
+		if !slices.Equal(got, test.want) {
+			t.Errorf("choices %v: keys at %v, want %v", test.choices, got, test.want)
+		}
+	}
+
+	// Rotated keys turn around a point that moves with them
+	selected := selectLayoutOptions(keys, []int{0, 1})
+	rotated := selected[len(selected)-1]
+	if rotated.RotationX != 0.5 || rotated.RotationY != 1.5 || rotated.RotationAngle != 10 {
+		t.Errorf("rotated key turns %g° around %g,%g, want 10° around 0.5,1.5", rotated.RotationAngle, rotated.RotationX, rotated.RotationY)
+	}
+	if keys[2].X != 5 {
+		t.Error("selecting moved the original keys")
+	}
+}
+
+func TestCheckLayoutOptions(t *testing.T) {
+	options := []viaOption{
+		{Name: "Split Backspace", Choices: []string{"off", "on"}},
+		{Name: "Bottom row", Choices: []string{"ANSI", "Tsangan", "WKL"}},
+	}
+	tests := []struct {
+		choices []int
+		err     string // Empty for none
+	}{
+		{nil, ""},
+		{[]int{1}, ""},
+		{[]int{0, 2}, ""},
+		{[]int{2, 0}, "layout option 0 (Split Backspace) must be one of 0 (off), 1 (on), got 2"},
+		{[]int{0, 0, 0}, "3 layout options given, but the keyboard has 2"},
+	}
+	for _, test := range tests {
+		err := checkLayoutOptions(options, test.choices)
+		if got := errString(err); got != test.err {
+			t.Errorf("checkLayoutOptions(%v) = %q, want %q", test.choices, got, test.err)
+		}
+	}
+
+	if choices, err := parseLayoutOptions("1, 0,2"); err != nil || !slices.Equal(choices, []int{1, 0, 2}) {
+		t.Errorf("parseLayoutOptions = %v, %v, want [1 0 2]", choices, err)
+	}
+	for _, s := range []string{"", "1,,2", "-1", "a"} {
+		if _, err := parseLayoutOptions(s); err == nil {
+			t.Errorf("parseLayoutOptions(%q) succeeded", s)
+		}
+	}
+}
+
+// The message of an error, or nothing
+func errString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
+
+func TestVIAKeycodes(t *testing.T) {
+	tests := []struct {
+		name   string
+		keymap viaKeymap
+		want   [][]string
+	}{
+		{
+			"VIA, in matrix order",
+			viaKeymap{Layers: [][]any{{"KC_A", "KC_B", "KC_C", 4.0}, {"KC_1", "KC_TRNS"}}},
+			[][]string{{"KC_A", "KC_B", "KC_C", "KC_NO"}, {"KC_1", "KC_TRNS"}},
+		},
+		{
+			"Vial, in rows with -1 for no key",
+			viaKeymap{Layout: [][][]any{{{"KC_A", -1.0, "KC_X"}, {"KC_C"}}}},
+			[][]string{{"KC_A", "KC_NO", "KC_C", ""}},
+		},
+	}
+	for _, test := range tests {
+		got := test.keymap.keycodes(2)
+		if !slices.EqualFunc(got, test.want, slices.Equal) {
+			t.Errorf("%s: keycodes = %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+const viaTestDefinition = `{
+	name: "Tiny VIA",
+	matrix: {rows: 2, cols: 2},
+	layouts: {
+		labels: ["Split Space"],
+		keymap: [
+			["0,0", "0,1"],
+			["1,0\n\n\n0,0", {x: 1}, "1,0\n\n\n0,1", "1,1\n\n\n0,1"],
+		],
+	},
+}`
+
+func TestParseVIAKeyboard(t *testing.T) {
+	tests := []struct {
+		keymap  string
+		choices []int
+		want    []string // Keycodes of the keys, base layer
+		layer   []string // and the next
+	}{
+		{`{"layers": [["KC_A", "KC_B", "KC_SPC", "KC_ENT"], ["KC_1", "KC_TRNS", "KC_TRNS", "KC_TRNS"]]}`, nil,
+			[]string{"KC_A", "KC_B", "KC_SPC"}, []string{"KC_1", "", ""}},
+		{`{"layout": [[["KC_A", "KC_B"], ["KC_SPC", "KC_ENT"]]]}`, []int{1},
+			[]string{"KC_A", "KC_B", "KC_SPC", "KC_ENT"}, nil},
+	}
+	for _, test := range tests {
+		kb, err := parseVIAKeyboard([]byte(viaTestDefinition), []byte(test.keymap), test.choices)
+		if err != nil {
+			t.Errorf("%s: %v", test.keymap, err)
+			continue
+		}
+		var codes, layer []string
+		for _, key := range kb.Keys {
+			codes = append(codes, key.Identity.Code)
+			if test.layer != nil {
+				var code string
+				if len(key.Layers) > 0 {
+					code = key.Layers[0].Code
+				}
+				layer = append(layer, code)
+			}
+		}
+		if kb.Meta.Name != "Tiny VIA" || !slices.Equal(codes, test.want) || !slices.Equal(layer, test.layer) {
+			t.Errorf("%s: %q with keys %q and layer %q, want Tiny VIA with %q and %q", test.keymap, kb.Meta.Name, codes, layer, test.want, test.layer)
+		}
+	}
+
+	// Keys have to be in the matrix the keymap is laid out by
+	outside := strings.Replace(viaTestDefinition, `"0,1"`, `"5,1"`, 1)
+	if _, err := parseVIAKeyboard([]byte(outside), []byte(`{"layers": [[]]}`), nil); errString(err) != "key 5,1 is outside the 2x2 matrix" {
+		t.Errorf("key outside the matrix: %v", err)
+	}
+	if _, err := parseVIAKeyboard([]byte(viaTestDefinition), []byte(`{}`), nil); errString(err) != "keymap has no layers" {
+		t.Errorf("keymap without layers: %v", err)
+	}
+	if _, err := parseVIAKeyboard([]byte(viaTestDefinition), []byte(`{"layers": [[]]}`), []int{2}); err == nil {
+		t.Error("choice that isn't there accepted")
+	}
+}
+
+func TestLoadVIAKeyboard(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "tiny.json")
+	if err := os.WriteFile(file, []byte(viaTestDefinition), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Without a keymap, there's just the shape
+	kb, err := loadKeyboard(file, KeyboardOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kb.Warning == "" || len(kb.Keys) != 3 {
+		t.Errorf("%d keys with warning %q, want 3 keys and a warning", len(kb.Keys), kb.Warning)
+	}
+	for _, key := range kb.Keys {
+		if key.Identity != (KeyIdentity{}) || slices.ContainsFunc(key.Labels, func(l string) bool { return l != "" }) {
+			t.Errorf("key at %g,%g is %+v labelled %q, want it blank", key.X, key.Y, key.Identity, key.Labels)
+		}
+	}
+
+	// The keymap saved next to it is found
+	keymap := `{"layers": [["KC_A", "KC_B", "KC_SPC", "KC_ENT"]]}`
+	if err := os.WriteFile(filepath.Join(dir, "tiny.layout.json"), []byte(keymap), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	kb, err = loadKeyboard(file, KeyboardOptions{LayoutOptions: []int{1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kb.Warning != "" || len(kb.Keys) != 4 || kb.Keys[3].Identity.Code != "KC_ENT" {
+		t.Errorf("%d keys with warning %q, want 4 keys ending with Enter and no warning", len(kb.Keys), kb.Warning)
+	}
+}
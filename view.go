@@ -1,12 +1,17 @@
 package main
 
 import (
+	"cmp"
 	"fmt"
 	"log"
-	// "math"
+	"math"
+	"slices"
 	"strings"
+	"time"
+	"typr2/typing"
 
 	"github.com/charmbracelet/lipgloss"
+	"github.com/rivo/uniseg"
 )
 
 // View function
@@ -25,6 +30,8 @@ func (m Model) View() string {
 		content = m.renderConfigScreen()
 	case ExtrasScreen:
 		content = m.renderExtrasScreen()
+	case ResultsScreen:
+		content = m.renderResultsScreen()
 	default:
 		content = "Unknown screen"
 	}
@@ -48,44 +55,60 @@ func (m Model) renderErrorScreen() string {
 	return m.centerContent(ui)
 }
 
-// Render start screen with menu
+// Render start screen with the course map and menu
 func (m Model) renderStartScreen() string {
 	title := titleStyle.Render("🚀 My TUI Application")
 
-	// Menu items with selection highlighting
-	menuItems := []string{
-		"Main Application",
-		"Configuration",
-		"Extras",
+	// Lessons, then the other screens, numbered for direct access
+	menuItem := func(i int, item string) string {
+		if i == m.menuSelection {
+			return selectedMenuItemStyle.Render(fmt.Sprintf("▶ %s", item))
+		}
+		return menuItemStyle.Render(fmt.Sprintf("  %s", item))
 	}
 
-	var renderedItems []string
-	for i, item := range menuItems {
-		if i == m.menuSelection {
-			renderedItems = append(renderedItems, selectedMenuItemStyle.Render(fmt.Sprintf("▶ %s", item)))
+	var course, practice []string
+	for i, lesson := range m.lessons {
+		status := m.lessonStatus(i)
+		status += strings.Repeat(" ", 2-lipgloss.Width(status)) // The lock is wider
+		item := fmt.Sprintf("%s %d. %s", status, i+1, lesson.Title)
+		if lesson.Pass != (PassCriteria{}) {
+			item += fmt.Sprintf(" (%.0f WPM, %.0f%%)", lesson.Pass.WPM, lesson.Pass.Accuracy)
+		}
+		if lesson.Course {
+			course = append(course, menuItem(i, item))
 		} else {
-			renderedItems = append(renderedItems, menuItemStyle.Render(fmt.Sprintf("  %s", item)))
-		}
-	}
-
-	menuContent := lipgloss.JoinVertical(lipgloss.Left,
-		"Welcome! Choose an option:",
-		"",
-		renderedItems[0],
-		renderedItems[1],
-		renderedItems[2],
-		"",
-		"Navigation:",
-		"• Use ↑/↓ or j/k to navigate",
-		"• Press Enter or Space to select",
-		"• Use number keys for direct access",
-		"• Press 'q' or Ctrl+C to quit",
+			practice = append(practice, menuItem(i, item))
+		}
+	}
+
+	var lines []string
+	if len(course) > 0 {
+		lines = append(lines, "Course:")
+		lines = append(lines, course...)
+	}
+	if len(practice) > 0 {
+		lines = append(lines, "Practice:")
+		lines = append(lines, practice...)
+	}
+	lines = append(lines, "",
+		menuItem(len(m.lessons), fmt.Sprintf("%d. Configuration", len(m.lessons)+1)),
+		menuItem(len(m.lessons)+1, fmt.Sprintf("%d. Extras", len(m.lessons)+2)),
 	)
 
-	menu := menuStyle.Render(menuContent)
-	status := helpStyle.Render(fmt.Sprintf("Terminal: %dx%d", m.termWidth, m.termHeight))
+	// What the selected lesson is about, wrapped to the width of the list
+	if m.menuSelection < len(m.lessons) {
+		if description := m.lessons[m.menuSelection].Description; description != "" {
+			width := max(lipgloss.Width(lipgloss.JoinVertical(lipgloss.Left, lines...)), 40)
+			lines = append(lines, "", helpStyle.UnsetMargins().Width(width).Render(description))
+		}
+	}
+
+	// The course needs the room, so keep the menu tight and the help short
+	menu := menuStyle.Padding(0, 2).Render(lipgloss.JoinVertical(lipgloss.Left, lines...))
+	help := helpStyle.Render("↑/↓ or j/k: navigate • Enter/Space: select • 1-9: jump • q: quit")
 
-	ui := lipgloss.JoinVertical(lipgloss.Left, title, menu, status)
+	ui := lipgloss.JoinVertical(lipgloss.Left, title, menu, help)
 	return m.centerContent(ui)
 }
 
@@ -111,13 +134,12 @@ func (m Model) renderMainScreen() string {
 
 	// title := titleStyle.Render("📱 Main Application")
 	// Calculate dimensions
-	// if h=24, then 8 rows for prompt and 16 for kb,
-	// or approx 3 rows per "key" if 5 rows
-	promptHeight := m.termHeight / 3
-	keyboardHeight := m.termHeight - promptHeight
+	// The prompt gets what it needs (up to 1/2 the screen) and the keyboard
+	// scales itself to whatever is left, minus the status line
+	promptSection := m.renderPrompt(m.termHeight / 2)
+	keyboardHeight := m.termHeight - 1 - lipgloss.Height(promptSection)
 
 	// Build keyboard display
-	promptSection := m.renderPrompt(promptHeight)
 	keyboardSection := m.renderKeyboard(keyboardHeight)
 
 	// return promptSection + "\n" + keyboardSection
@@ -129,22 +151,40 @@ func (m Model) renderMainScreen() string {
 func (m Model) renderConfigScreen() string {
 	title := titleStyle.Render("⚙️  Configuration")
 
-	msg := fmt.Sprintf(`	Configuration settings:
-
-• Theme settings
-• User preferences  
-• Data sources
-• Export options
-• Keyboard shortcuts
+	msg := fmt.Sprintf(`Configuration file: %[2]s
 
 Current Configuration:
 • Command key: '%[1]s' (use '%[1]shelp' for commands)
-• Search key: '%[1]s' (reserved for future search)
-
-Try these commands:
+• Search key: '%[3]s' (reserved for future search)
+• Keyboard file: %[4]s, layout: %[14]s, legends: %[15]s
+• Theme: %[5]s (available: %[9]s)
+• Auto advance: %[6]t (after %[7]dms)
+• Heatmap: %[8]s, error mode: %[13]s
+• Next key hint: %[10]t, finger colors: %[11]t, drill seed: %[12]d
+
+Try these commands (changes are saved automatically):
 • %[1]sset commandkey ; (change to semicolon)
-• %[1]sset commandkey : (change to colon - default)
-• %[1]sset searchkey ? (change search key)`, m.config.CommandKey)
+• %[1]sset keyboard [-format|-keymap|-options] <file>, %[1]sset layout none|%[16]s, %[1]sset legends printed|layout
+• %[1]sset theme light (or add your own in themes/*.json)
+• %[1]sset autoadvance on|off, %[1]sset errormode free|stop-on-error|stop-on-word|strict
+• %[1]sset hint on|off, %[1]sset fingers on|off, %[1]sset drillseed <n>
+• %[1]sw (write the config file)`,
+		m.config.CommandKey,
+		m.configPath,
+		m.config.SearchKey,
+		m.config.KeyboardFile,
+		m.config.Theme,
+		m.config.Typing.AutoAdvance,
+		m.config.Typing.AdvanceDelay,
+		m.config.Typing.Heatmap,
+		strings.Join(themeNames(), ", "),
+		m.config.Typing.Hint,
+		m.config.Typing.FingerColors,
+		m.config.Typing.DrillSeed,
+		m.config.Typing.ErrorMode,
+		cmp.Or(m.config.Layout, "as printed"),
+		m.config.Legends,
+		strings.Join(layoutNames(), "|"))
 	content := contentStyle.Render(msg)
 
 	help := helpStyle.Render("Press 'Esc' or 'b' to go back • 'q' or Ctrl+C to quit")
@@ -157,15 +197,11 @@ Try these commands:
 func (m Model) renderExtrasScreen() string {
 	title := titleStyle.Render("✨ Extras")
 
-	content := contentStyle.Render(`Additional features:
-
-• Help documentation
-• About information
-• Debug tools
-• Export data
-• Import settings
+	content := contentStyle.Render(fmt.Sprintf(`Layout analysis: %s typing the built-in quotes
 
-[Extra features would go here]`)
+%s
+Analyze your own text with: typr2 analyze -layouts printed,dvorak <file>`,
+		m.keyboard.Meta.Name, m.layoutAnalysis))
 
 	help := helpStyle.Render("Press 'Esc' or 'b' to go back • 'q' or Ctrl+C to quit")
 
@@ -173,6 +209,42 @@ func (m Model) renderExtrasScreen() string {
 	return m.centerContent(ui)
 }
 
+// Render the results of a finished test
+func (m Model) renderResultsScreen() string {
+	title := titleStyle.Render("🏁 Results")
+
+	snapshot := m.session.Snapshot()
+	stats := snapshot.Stats(snapshot.End)
+	test := m.mode.String()
+	if m.mode.Kind != modeZen {
+		test += " | " + m.sourceName
+	}
+
+	wpm := resultsStyle.Render(fmt.Sprintf("%.0f WPM (net %.0f)", stats.GrossWPM, stats.NetWPM))
+	msg := fmt.Sprintf(`Test: %s
+
+%s
+
+• Accuracy: %.0f%%
+• Errors: %d (%d left uncorrected)
+• Consistency: %.0f%%
+• Characters: %d, words: %d
+• Time: %.1fs`,
+		test,
+		wpm,
+		stats.Accuracy,
+		stats.Errors, stats.Uncorrected,
+		stats.Consistency,
+		stats.Characters, typedWords(snapshot),
+		stats.Duration.Seconds())
+	content := contentStyle.Render(msg)
+
+	help := helpStyle.Render("Tab/Enter: next test • Esc/b: back to start • Ctrl+C: quit")
+
+	ui := lipgloss.JoinVertical(lipgloss.Left, title, content, help)
+	return m.centerContent(ui)
+}
+
 // Center content both horizontally and vertically
 func (m Model) centerContent(content string) string {
 	// Reserve space for status line (subtract 1 from height)
@@ -208,7 +280,8 @@ func (m Model) renderWithStatusLine(content string) string {
 	statusLine := m.renderStatusLine()
 
 	// If we're in command mode, show command line instead of just status
-	if m.commandMode != NormalMode {
+	// (also used to show the result of the last command)
+	if m.commandMode != NormalMode || m.commandError != "" {
 		commandLine := m.renderCommandLine()
 		return lipgloss.JoinVertical(lipgloss.Left, content, commandLine)
 	}
@@ -229,6 +302,8 @@ func (m Model) renderStatusLine() string {
 		screenName = "CONFIG"
 	case ExtrasScreen:
 		screenName = "EXTRAS"
+	case ResultsScreen:
+		screenName = "RESULTS"
 	}
 
 	// Left side: screen info
@@ -257,8 +332,10 @@ func (m Model) renderCommandLine() string {
 	}
 
 	// Show error if there is one, otherwise show command input
+	// Joined errors come one per line, which the status line doesn't have room for
 	if m.commandError != "" {
-		return commandErrorStyle.Width(m.termWidth).Render(fmt.Sprintf(" %s", m.commandError))
+		message := strings.ReplaceAll(m.commandError, "\n", "; ")
+		return commandErrorStyle.Width(m.termWidth).Render(fmt.Sprintf(" %s", message))
 	}
 
 	commandContent := fmt.Sprintf(" %s%s", prefix, m.commandInput)
@@ -284,370 +361,583 @@ func (m Model) renderKeyboard(maxHeight int) string {
 
 	var info string = ""
 
-	// Key styles
-	// TODO: get colors from keys
-	normalKeyStyle := lipgloss.NewStyle().
-		// Border(lipgloss.RoundedBorder()).
-		// BorderForeground(lipgloss.Color("240")).
-		// Background(lipgloss.Color("236")).
-		// Foreground(lipgloss.Color("255")).
-		// Align(lipgloss.Center).
-		// Padding(0, 1)
-		Padding(0)
-
-	pressedKeyStyle := lipgloss.NewStyle().
-		// Border(lipgloss.RoundedBorder()).
-		// BorderForeground(lipgloss.Color("46")).
-		// Background(lipgloss.Color("46")).
-		// Foreground(lipgloss.Color("0")).
-		// Align(lipgloss.Center).
-		// Padding(0, 1)
-		Padding(0)
-
-	specialKeyStyle := lipgloss.NewStyle().
-		// Border(lipgloss.RoundedBorder()).
-		// BorderForeground(lipgloss.Color("33")).
-		// Background(lipgloss.Color("237")).
-		// Foreground(lipgloss.Color("33")).
-		// Align(lipgloss.Center).
-		// Padding(0, 1)
-		Padding(0)
-
-	// Group keys by row (Y coordinate)
-	rows := m.getKeyboardRows()
-
-	/*
-		DEBUG CODE - REMOVE
-	*/
-	// {
-	// 	// info += fmt.Sprintf("# rows: %d\n", len(rows))
-	// 	for r := range rows {
-	// 		// for r := len(rows); r > 0; r-- {
-	// 		// info += fmt.Sprintf(" > row[%d]:\n", r)
-	// 		for range rows[r] {
-	// 			info += fmt.Sprint("┌   ┐")
-	// 		}
-	// 		info += fmt.Sprint("\n")
-	// 		for k := range rows[r] {
-	// 			currentRow := rows[r][k]
-	// 			label := currentRow.Labels[0]
-	// 			if len(label) > 1 {
-	// 				label = string(label[0])
-	// 			} else if len(label) == 0 {
-	// 				label = " "
-	// 			}
-	// 			//info += fmt.Sprintf("| %v |", label)
-	// 			log.Printf("%v is [%.2fu x %.2fu] at (%d, %d)",
-	// 				strings.Join(currentRow.Labels, ""),
-	// 				currentRow.Width, currentRow.Height,
-	// 				currentRow.X, currentRow.Y)
-	// 		}
-	// 		info += fmt.Sprint("\n")
-	// 		for range rows[r] {
-	// 			info += fmt.Sprint("└   ┘")
-	// 		}
-	// 		info += fmt.Sprint("\n")
-	// 	}
-	// }
-	/*
-		END DEBUG CODE
-	*/
-
-	var keyboardLines []string
+	// Add keyboard info
+	if m.keyboard.Meta.Name != "" {
+		info += fmt.Sprintf("Keyboard: %s", m.keyboard.Meta.Name)
+	}
+	if m.keyboard.Meta.Author != "" {
+		info += fmt.Sprintf(" by %s", m.keyboard.Meta.Author)
+	}
+	if layout, ok := layouts[m.config.Layout]; ok {
+		info += fmt.Sprintf(" (%s)", layout.Name)
+	}
+	if layers := m.keyboard.Layers; len(layers) > 0 {
+		info += fmt.Sprintf(" | Layer: %s", layers[m.activeLayer()])
+	}
+	if guide := m.fingerGuide(); guide != "" {
+		if info != "" {
+			info += " | "
+		}
+		info += guide
+	}
 
 	// Calculate available height for keyboard content
-	// TODO: ??claude?? Account for border (2 lines), padding (2 lines), margin (2 lines), and info line (3 lines)
-	//       maxHeight should already be 2/3 of term rows
 	headerHeight := 0
+	if info != "" {
+		headerHeight = 1
+	}
 	availableHeight := maxHeight - headerHeight
-	// keyboardWidth := getKeyboardWidth(rows)
-	// how many columns wide a 1u key can be
-	// one unit should be 4 columns wide - this allows for dividing into fourths for certain key sizes
-
-	keyUnitWidth := 4
-	//maxUnitWidth := math.Round(float64(m.termWidth) / (keyboardWidth * float64(keyUnitWidth)))
-
-	// Render each row, respecting height constraints
-	renderedRows := 0
-	// info += fmt.Sprintf("Keyboard layout (%d rows):\n", maxY+1)
-	// info += fmt.Sprintf("maxHeight: %d\n", maxHeight)
-	// info += fmt.Sprintf("headerHeight: %d\n", headerHeight)
-	// info += fmt.Sprintf("availableHeight: %d\n", availableHeight)
-	for y := 0; y <= len(rows) && renderedRows < availableHeight; y++ {
-		// info += fmt.Sprintf("renderedRows: %d\n", renderedRows)
-		if rowKeys, exists := rows[y]; exists {
-			var row []string
-
-			// info += fmt.Sprintf("\nRow %d: ", y)
-			for _, key := range rowKeys {
-				// // Determine key label
-				// label := ""
-				// if len(key.Labels) >= 5 {
-				// 	label = key.Labels[4]
-				// } else {
-				// 	label = key.Labels[0]
-				// }
-
-				// Keys have up to 12 labels, in 3 columns and 3 rows, plus a "front face" row
-				label1 := lipgloss.JoinHorizontal(lipgloss.Top, key.Labels[:3]...)
-				label2 := lipgloss.JoinHorizontal(lipgloss.Top, key.Labels[3:6]...)
-				label3 := lipgloss.JoinHorizontal(lipgloss.Top, key.Labels[6:9]...)
-				// TODO: for now, ignoring front labels
-				// label4 := lipgloss.JoinHorizontal(lipgloss.Top, key.Labels[9:]...)
-
-
-				// Determine key width
-				// width := max(3, int(key.Width)) //min(max(int(key.Width*4), 3), 12)
-				// width := min(max(int(key.Width*4), 3), 12)
-
-				// convert key unit width to actual terminal column width
-				keyWidth := max(keyUnitWidth, int(key.Width*float64(keyUnitWidth)))
-				log.Printf("%f key.Width -> %d term cols", key.Width, keyWidth)
-
-				// Shorten labels that are too long
-				if keyWidth < len(label1) {
-					label1 = label1[:keyWidth]
-				}
-				if keyWidth < len(label2) {
-					label2 = label2[:keyWidth]
-				}
-				if keyWidth < len(label1) {
-					label3 = label3[:keyWidth]
-				}
 
-				// Join the label rows to form the keycap
-				label := lipgloss.JoinVertical(lipgloss.Left, label1, label2, label3) //, label4)
-
-				// Check if key is pressed
-				// TODO: this now needs to check what the actual key is vs. what the label(s) might contain...
-				keyPressed := m.pressedKeys[strings.ToUpper(label)]
-				// || (label == "spaaaaaaaaaaaaaaaaaaaaaaaaaace" && m.pressedKeys["SPACE"])
-
-				// Choose style
-				var style lipgloss.Style
-				if keyPressed {
-					style = pressedKeyStyle
-				} else if isSpecialKey(label) {
-					style = specialKeyStyle
-				} else {
-					style = normalKeyStyle
-				}
+	// How many terminal columns and rows one key unit (1u) gets
+	keyUnitWidth, keyUnitHeight, tier := scaleKeyboard(m.keyboard, m.termWidth, availableHeight)
+	log.Printf("keyboard unit: %dx%d (tier %d)", keyUnitWidth, keyUnitHeight, tier)
 
-				// Apply custom colors if specified
-				if key.Color != "" {
-					style = style.Background(lipgloss.Color(key.Color))
-				}
-				if key.TextColor != "" {
-					style = style.Foreground(lipgloss.Color(key.TextColor))
-				}
+	// Place keys on a canvas by their real coordinates, relative to the
+	// top-left corner of the keyboard
+	minX, minY, maxX, maxY := m.keyboard.Bounds()
+	toCol := func(x float64) int { return int(math.Round((x - minX) * float64(keyUnitWidth))) }
+	toRow := func(y float64) int { return int(math.Round((y - minY) * float64(keyUnitHeight))) }
+
+	cv := newCanvas(min(toCol(maxX), m.termWidth), min(toRow(maxY), availableHeight))
+
+	heat := m.heatmapValues()
+	hint := m.hintKeys()
+	layer := m.activeLayer()
+
+	for i, key := range m.keyboard.Keys {
+		key = m.displayKey(key, layer)
+		if key.Decal {
+			// Decals are just labels without a keycap
+			continue
+		}
+
+		// Choose style
+		var style lipgloss.Style
+		if !key.Identity.IsPrintable() {
+			style = specialKeyStyle
+		} else {
+			style = normalKeyStyle
+		}
+
+		// Without borders, keycaps are only visible by their background
+		if tier != tierBordered {
+			style = style.Inherit(keycapStyle)
+		}
+
+		// Apply custom colors if specified
+		if key.Color != "" {
+			style = style.Background(lipgloss.Color(key.Color))
+		}
+		if key.TextColor != "" {
+			style = style.Foreground(lipgloss.Color(key.TextColor))
+		}
+
+		if m.config.Typing.FingerColors {
+			if color, ok := fingerColor(key.Finger); ok {
+				style = style.Background(color).Foreground(currentTheme.KeyPressedFG)
+			}
+		}
+
+		// The heatmap overrides the keyboard's own colors
+		if h, ok := heat[i]; ok {
+			style = style.Background(heatColor(h)).Foreground(currentTheme.KeyPressedFG)
+		}
+
+		// Keys to press next, and keys just pressed, stand out over everything
+		if slices.Contains(hint, i) {
+			style = hintKeyStyle.Inherit(style)
+		}
+		if _, pressed := m.pressedKeys[i]; pressed {
+			style = pressedKeyStyle.Inherit(style)
+		}
+
+		// Rotated keys are snapped to the nearest cells
+		toRect := func(x, y, w, h float64) cellRect {
+			col, row := toCol(x), toRow(y)
+			return cellRect{col, row, toCol(x+w) - col, toRow(y+h) - row}
+		}
+		primary := toRect(key.Rect())
+		secondary := primary
+		if !key.IsRectangular() {
+			secondary = toRect(key.Rect2())
+		}
+
+		switch tier {
+		case tierBordered:
+			drawKey(cv, primary, secondary, key, cv.addStyle(style))
+		default:
+			drawFlatKey(cv, primary, secondary, key, tier, cv.addStyle(style))
+		}
+	}
+
+	keyboard := cv.String()
+
+	if info == "" {
+		return keyboard
+	}
+	ui := lipgloss.JoinVertical(lipgloss.Left, info, keyboard)
+	return ui
+}
+
+// How much detail the onscreen keyboard is drawn with, from most to least
+type keyboardTier int
+
+const (
+	tierBordered   keyboardTier = iota // Rounded borders and up to 3 rows of legends
+	tierBorderless                     // Shaded keycaps with a gap between rows
+	tierCompact                        // A single line per key row
+	tierMini                           // One character per key
+)
+
+// Limits on the size of a key unit, in terminal cells
 /*
-sizes...
-... just for the keyboard
-
-// Normal border
-8*15=120 wide
-5*5=25 tall
-┌──────┐
-│~     │
-│      │
-│`     │
-└──────┘
-
-// Rounded border
-// same as normal border
-╭──────╮
-│~     │
-│      │
-│`     │
-╰──────╯
-
-// no border:
-6x15=90 wide
-3x5=15 tall
-~     
-      
-`     
-
-
-and then...
-
-// 8 + 2 for the border = 10 cols for a 2u key
-// but... it's 4 tall, should only be 3 tall
-╭────────╮
-│Backspac│
-│e       │
-│        │
-│        │
-╰────────╯
-
-// 1u key is 4 wide + 2 border = 6 and 3+2=5 tall
-// we could stand to pad it and make it 6 wide + 2 border = 8 columns but then it's 120 wide minimum
-╭────╮
-│~   │
-│    │
-│`   │
-╰────╯
+	sizes...
+	A 1u key at the largest size is 8 columns of legend + 2 border = 10
+	columns wide and 3 rows of legend + 2 border = 5 rows tall. Beyond that
+	there aren't any more legends to show.
+
+	╭────────╮
+	│~       │
+	│        │
+	│`       │
+	╰────────╯
 */
+const (
+	maxKeyUnitWidth  = 10
+	maxKeyUnitHeight = 5
+)
+
+// Find the size of a key unit that fits the keyboard into the given area, and
+// the tier of detail that size allows
+func scaleKeyboard(kb Keyboard, width, height int) (unitWidth, unitHeight int, tier keyboardTier) {
+	kbWidth := math.Max(getKeyboardWidth(kb), 1)
+	kbHeight := math.Max(getKeyboardHeight(kb), 1)
+
+	unitWidth = min(int(float64(width)/kbWidth), maxKeyUnitWidth)
+	unitHeight = min(int(float64(height)/kbHeight), maxKeyUnitHeight)
+
+	// A terminal cell is about twice as tall as it is wide, so don't let keys
+	// get much wider than that or they stop looking like keys
+	unitWidth = max(min(unitWidth, unitHeight*2+2), 1)
+	unitHeight = max(unitHeight, 1)
+
+	switch {
+	case unitHeight >= 3 && unitWidth >= 4:
+		tier = tierBordered
+	case unitHeight >= 2 && unitWidth >= 3:
+		tier = tierBorderless
+	case unitWidth >= 3:
+		tier = tierCompact
+	default:
+		tier = tierMini
+	}
+
+	return unitWidth, unitHeight, tier
+}
+
+// A rectangle of canvas cells
+type cellRect struct {
+	col, row, width, height int
+}
+
+func (r cellRect) contains(col, row int) bool {
+	return col >= r.col && col < r.col+r.width && row >= r.row && row < r.row+r.height
+}
+
+// Draw a single keycap with a rounded border and its top 3 rows of legends
+// The outline follows the union of the key's rectangles, so an ISO Enter
+// gets its L-shape. Legends are drawn inside the primary rectangle.
+func drawKey(cv *canvas, primary, secondary cellRect, key Key, style int) {
+	if primary.width < 2 || primary.height < 2 {
+		return
+	}
+
+	inside := func(col, row int) bool {
+		return primary.contains(col, row) || secondary.contains(col, row)
+	}
 
-				width := int(keyWidth)
-				renderedKey := style.
-					Border(lipgloss.RoundedBorder()).
-					Width(width).
-					Height(3).
-					Render(label)
-				// rowDisplay.WriteString(renderedKey)
-				// rowDisplay.WriteString(" ")
-				// row := lipgloss.JoinHorizontal(lipgloss.Top, renderedKey)
-				log.Printf("\n%s\n", renderedKey)
-				row = append(row, renderedKey)
+	// Border cells are inside the key but touch something outside of it
+	border := func(col, row int) bool {
+		if !inside(col, row) {
+			return false
+		}
+		for dy := -1; dy <= 1; dy++ {
+			for dx := -1; dx <= 1; dx++ {
+				if !inside(col+dx, row+dy) {
+					return true
+				}
+			}
+		}
+		return false
+	}
+
+	left := min(primary.col, secondary.col)
+	top := min(primary.row, secondary.row)
+	right := max(primary.col+primary.width, secondary.col+secondary.width)
+	bottom := max(primary.row+primary.height, secondary.row+secondary.height)
 
+	for row := top; row < bottom; row++ {
+		for col := left; col < right; col++ {
+			if !inside(col, row) {
+				continue
+			}
+			if !border(col, row) {
+				cv.set(col, row, " ", style)
+				continue
 			}
 
-			rowDisplay := lipgloss.JoinHorizontal(lipgloss.Top, row...)
+			// Pick the box-drawing character that connects to neighbouring border cells
+			up, down := border(col, row-1), border(col, row+1)
+			l, r := border(col-1, row), border(col+1, row)
+			var char string
+			switch {
+			case down && r && !up && !l:
+				char = "╭"
+			case down && l && !up && !r:
+				char = "╮"
+			case up && r && !down && !l:
+				char = "╰"
+			case up && l && !down && !r:
+				char = "╯"
+			case up && down:
+				char = "│"
+			default:
+				char = "─"
+			}
+			cv.set(col, row, char, style)
+		}
+	}
 
-			// info += fmt.Sprintf("\nrowDisplay %v: ", rowDisplay.String())
-			//keyboardLines = append(keyboardLines, rowDisplay.String())
-			keyboardLines = append(keyboardLines, rowDisplay)
-			renderedRows++
+	// Stepped keys show where the raised part of the keycap ends
+	if key.Stepped {
+		for row := primary.row; row < primary.row+primary.height; row++ {
+			for _, col := range []int{primary.col, primary.col + primary.width - 1} {
+				if inside(col, row) && !border(col, row) {
+					cv.set(col, row, "┊", style)
+				}
+			}
+		}
+		for col := primary.col; col < primary.col+primary.width; col++ {
+			for _, row := range []int{primary.row, primary.row + primary.height - 1} {
+				if inside(col, row) && !border(col, row) {
+					cv.set(col, row, "┄", style)
+				}
+			}
 		}
-		// TODO: Else for empty rows?
 	}
 
-	// Add truncation indicator if we couldn't fit all rows
-	// if renderedRows < maxY+1 {
-	// 	keyboardLines = append(keyboardLines, "... (keyboard truncated to fit)")
-	// }
+	// Keys have up to 12 labels, in 3 columns and 3 rows, plus a "front face" row
+	// TODO: for now, ignoring front labels
+	drawLegends(cv, primary.col+1, primary.row+1, primary.width-2, primary.height-2, drawnLabels(key), style)
+}
 
-	// Join all rows
-	// keyboard := strings.Join(keyboardLines, "\n")
-	keyboard := lipgloss.JoinVertical(lipgloss.Center, keyboardLines...)
+// Draw a keycap without a border, as a shaded block over the union of the
+// key's rectangles. The last column (and row, if there is room) is left
+// blank so neighbouring keys can be told apart.
+func drawFlatKey(cv *canvas, primary, secondary cellRect, key Key, tier keyboardTier, style int) {
+	inside := func(col, row int) bool {
+		return primary.contains(col, row) || secondary.contains(col, row)
+	}
 
-	// Add keyboard info
-	// TODO: these fields could be empty
-	// info += fmt.Sprintf("keyboard lines: %d\n", len(keyboardLines))
-	// info += fmt.Sprintf("rows: %d\n", len(rows))
-	// info += fmt.Sprintf("rendered rows: %d\n", renderedRows)
-	if m.keyboard.Meta.Name != "" {
-		info += fmt.Sprintf("Keyboard: %s", m.keyboard.Meta.Name)
+	gapBottom := tier == tierBorderless
+
+	left := min(primary.col, secondary.col)
+	top := min(primary.row, secondary.row)
+	right := max(primary.col+primary.width, secondary.col+secondary.width)
+	bottom := max(primary.row+primary.height, secondary.row+secondary.height)
+
+	for row := top; row < bottom; row++ {
+		for col := left; col < right; col++ {
+			if !inside(col, row) {
+				continue
+			}
+			if primary.width > 1 && !inside(col+1, row) {
+				continue
+			}
+			if gapBottom && !inside(col, row+1) {
+				continue
+			}
+			cv.set(col, row, " ", style)
+		}
 	}
-	if m.keyboard.Meta.Author != "" {
-		info += fmt.Sprintf(" by %s", m.keyboard.Meta.Author)
+
+	width := max(primary.width-1, 1)
+	height := primary.height
+	if gapBottom {
+		height--
 	}
 
-	// Apply height constraint to the final rendered output
-	//finalContent := info + "\n\n" + keyboard
-	styledKeyboard := lipgloss.NewStyle().
-		// Border(lipgloss.RoundedBorder()).
-		// BorderForeground(lipgloss.Color("62")).
-		// Padding(1).
-		// Margin(1).
-		//Height(maxHeight).
-		Render(keyboard)
-		//Render(finalContent)
-
-	/*
-		DEBUG CODE - REMOVE
-	*/
-	// styledKeyboard = ""
-	/*
-		END DEBUG CODE
-	*/
-
-	// return styledKeyboard
-	ui := lipgloss.JoinVertical(lipgloss.Left, info, styledKeyboard)
-	return ui //m.centerContent(ui)
+	if tier == tierMini {
+		// Only room for the first character of the main legend
+		legend := []rune(primaryLegend(Key{Labels: drawnLabels(key)}))
+		if len(legend) > 0 {
+			cv.text(primary.col, primary.row, string(legend[0]), width, style)
+		}
+		return
+	}
+
+	drawLegends(cv, primary.col, primary.row, width, height, drawnLabels(key), style)
 }
 
-// Render the onscreen prompt
-func (m Model) renderPrompt(maxHeight int) string {
-	// Style definitions
-	promptStyle := lipgloss.NewStyle().
-		Border(lipgloss.RoundedBorder()).
-		BorderForeground(lipgloss.Color("62")).
-		Padding(1, 2).
-		Margin(1)
-
-	correctStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("46"))                                    // Green
-	incorrectStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("196"))                                 // Red
-	currentStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("226")).Background(lipgloss.Color("240")) // Yellow bg
-	futureStyle := lipgloss.NewStyle().Foreground(lipgloss.Color("244"))                                    // Gray
-
-	// Build prompt display
-	var promptDisplay strings.Builder
-	for i, char := range m.prompt {
-		if i < len(m.userInput) {
-			// Character has been typed
-			if i < len(m.userInput) && rune(m.userInput[i]) == char {
-				promptDisplay.WriteString(correctStyle.Render(string(char)))
-			} else {
-				promptDisplay.WriteString(incorrectStyle.Render(string(char)))
+// The labels of a key worth drawing
+// Empty lines in KLE legends are read as "␣" placeholders, which only mean
+// something on the spacebar.
+func drawnLabels(key Key) []string {
+	if key.Identity.Code == "KC_SPC" {
+		return key.Labels
+	}
+	labels := slices.Clone(key.Labels)
+	for i, label := range labels {
+		if label == "␣" {
+			labels[i] = ""
+		}
+	}
+	return labels
+}
+
+// Draw the 3x3 legend grid of a key into the given area, with left, center
+// and right legends aligned accordingly. With only 2 rows the middle row is
+// dropped, and with just 1 row only the main legend is drawn.
+func drawLegends(cv *canvas, col, row, width, height int, labels []string, style int) {
+	if height < 1 || width < 1 {
+		return
+	}
+
+	if height == 1 {
+		cv.text(col, row, primaryLegend(Key{Labels: labels}), width, style)
+		return
+	}
+
+	gridRows := []int{0, 1, 2}
+	if height == 2 {
+		gridRows = []int{0, 2}
+	}
+
+	for y, r := range gridRows {
+		for c := 0; c < 3; c++ {
+			i := r*3 + c
+			if i >= len(labels) || labels[i] == "" {
+				continue
 			}
-		} else if i == m.currentChar {
-			// Current character to type
-			promptDisplay.WriteString(currentStyle.Render(string(char)))
-		} else {
-			// Future characters
-			promptDisplay.WriteString(futureStyle.Render(string(char)))
+
+			label := labels[i]
+			labelWidth := min(lipgloss.Width(label), width)
+			x := col
+			switch c {
+			case 1:
+				x = col + (width-labelWidth)/2
+			case 2:
+				x = col + width - labelWidth
+			}
+			cv.text(x, row+y, label, width, style)
 		}
 	}
+}
 
-	// Progress info
-	progress := fmt.Sprintf("Progress: %d/%d characters | Prompt %d/%d",
-		len(m.userInput), len(m.prompt), m.promptIndex+1, len(m.prompts))
+// Width of the text inside the prompt box, in cells: whatever the terminal
+// leaves after the box's border, padding and margin
+func (m Model) promptWidth() int {
+	return max(m.termWidth-promptStyle.GetHorizontalFrameSize(), 20)
+}
 
-	instructions := "Tab: Next prompt | Ctrl+C/Q: Quit"
+// The part of the target to show in width cells: all of it if it fits,
+// otherwise a window starting at a word a bit before the cursor
+// Wide characters like CJK take two cells, so the window is measured in
+// cells rather than characters.
+func promptWindow(snapshot typing.Snapshot, width int) (from, to int) {
+	if uniseg.StringWidth(strings.Join(snapshot.Target, "")) <= width {
+		return 0, len(snapshot.Target)
+	}
 
-	return promptStyle.Render(
-		fmt.Sprintf("Type: %s\n\n%s\n\n%s\n%s",
-			m.prompt,
-			promptDisplay.String(),
-			progress,
-			instructions))
-		//Height(maxHeight).
+	from = max(snapshot.Cursor-width/3, 0)
+	for from > 0 && snapshot.Target[from-1] != " " {
+		from--
+	}
 
+	cells := 0
+	for to = from; to < len(snapshot.Target); to++ {
+		if cells += uniseg.StringWidth(snapshot.Target[to]); cells > width {
+			break
+		}
+	}
+	return from, to
 }
 
-// Returns a map of keyboard rows based on the currently loaded keyboard
-func (m Model) getKeyboardRows() map[int][]Key {
-	rows := make(map[int][]Key)
-	maxY := 0
-	for _, key := range m.keyboard.Keys {
-		y := key.Y
-		rows[y] = append(rows[y], key)
-		if y > maxY {
-			maxY = y
+// Wrap the target into lines of at most width cells, breaking after spaces
+// (or anywhere in a word too long for a line), as ranges of the target
+func promptLines(snapshot typing.Snapshot, width int) [][2]int {
+	var lines [][2]int
+	from, cells, lastSpace := 0, 0, -1
+	for i, g := range snapshot.Target {
+		if cells += uniseg.StringWidth(g); cells > width && i > from {
+			to := i
+			if lastSpace >= from {
+				to = lastSpace + 1
+			}
+			lines = append(lines, [2]int{from, to})
+			from, lastSpace = to, -1
+			cells = uniseg.StringWidth(strings.Join(snapshot.Target[from:i+1], ""))
+		}
+		if g == " " {
+			lastSpace = i
+		}
+	}
+	return append(lines, [2]int{from, len(snapshot.Target)})
+}
+
+// The lines of a wrapped target to show when only n fit: the line with the
+// cursor, after the one before it
+func visibleLines(lines [][2]int, cursor, n int) [][2]int {
+	current := len(lines) - 1
+	for i, line := range lines {
+		if cursor < line[1] {
+			current = i
+			break
+		}
+	}
+
+	n = max(min(n, len(lines)), 1)
+	start := max(min(current-min(1, n-1), len(lines)-n), 0)
+	return lines[start : start+n]
+}
+
+// Render part of the target, colored by state
+func renderTarget(snapshot typing.Snapshot, from, to int) string {
+	var promptDisplay strings.Builder
+	for i := from; i < to; i++ {
+		char := snapshot.Target[i]
+		switch {
+		case snapshot.States[i] == typing.Correct || snapshot.States[i] == typing.Corrected:
+			promptDisplay.WriteString(correctStyle.Render(char))
+		case snapshot.States[i] == typing.Incorrect:
+			promptDisplay.WriteString(incorrectStyle.Render(char))
+		case i == snapshot.Cursor:
+			// Current character to type
+			promptDisplay.WriteString(currentStyle.Render(char))
+		default:
+			// Future characters
+			promptDisplay.WriteString(futureStyle.Render(char))
 		}
 	}
-	return rows
+	return promptDisplay.String()
 }
 
-// Find the total u-width of the longest row
-func getKeyboardWidth(kbRows map[int][]Key) float64 {
-	maxWidth := 0.0
-	for row := range(kbRows) {
-		rowWidth := 0.0
-		for key := range(row) {
-			rowWidth += kbRows[row][key].Width
+// Render the onscreen prompt in at most maxHeight lines
+// Prompts wrap over as many lines as fit, while tests scroll through a
+// window of a single line.
+func (m Model) renderPrompt(maxHeight int) string {
+	snapshot := m.session.Snapshot()
+	width := m.promptWidth()
+
+	var display string
+	if m.mode.Kind == modePrompt {
+		// Room left after the box and the progress, stats and instructions
+		rows := maxHeight - promptStyle.GetVerticalFrameSize() - 4
+		var parts []string
+		for _, line := range visibleLines(promptLines(snapshot, width), snapshot.Cursor, rows) {
+			parts = append(parts, renderTarget(snapshot, line[0], line[1]))
 		}
-		if rowWidth > maxWidth {
-			maxWidth = rowWidth
+		display = strings.Join(parts, "\n")
+	} else {
+		from, to := promptWindow(snapshot, width)
+		display = renderTarget(snapshot, from, to)
+	}
+	if snapshot.Free && !snapshot.Done {
+		// The cursor is always at the end without a target
+		display += currentStyle.Render(" ")
+	}
+
+	// Progress info, counting the prompts of sources that have a fixed number
+	prompt := fmt.Sprint(m.promptNumber)
+	if sized, ok := m.source.(sizedSource); ok {
+		prompt = fmt.Sprintf("%d/%d", (m.promptNumber-1)%sized.Len()+1, sized.Len())
+	}
+	progress := fmt.Sprintf("Progress: %d/%d characters | Prompt %s | %s",
+		snapshot.Cursor, len(snapshot.Target), prompt, m.sourceName)
+	target := strings.Join(snapshot.Target, "")
+	instructions := fmt.Sprintf("Tab: Next prompt | Esc %s: Command | Ctrl+C/Q: Quit", m.config.CommandKey)
+
+	// Tests count down the time or words instead
+	switch m.mode.Kind {
+	case modeTime:
+		left := math.Ceil(m.timeLeft(snapshot, time.Now()).Seconds())
+		progress = fmt.Sprintf("Time left: %.0fs | Words: %d | Test: %s | %s",
+			left, typedWords(snapshot), m.mode, m.sourceName)
+	case modeWords:
+		progress = fmt.Sprintf("Words: %d/%d | Test: %s | %s",
+			typedWords(snapshot), m.mode.Length, m.mode, m.sourceName)
+	case modeZen:
+		progress = fmt.Sprintf("Characters: %d | Words: %d | Test: zen", snapshot.Cursor, typedWords(snapshot))
+		target = "anything you like, Enter to finish"
+	}
+	if m.mode.Kind != modePrompt {
+		instructions = fmt.Sprintf("Tab: Restart | Esc %s: Command | Ctrl+C/Q: Quit", m.config.CommandKey)
+	}
+
+	// Live stats, or the results once the prompt is done
+	stats := snapshot.Stats(time.Now())
+	statsLine := fmt.Sprintf("WPM: %.0f (net %.0f) | Acc: %.0f%% | Errors: %d | Cons: %.0f%%",
+		stats.GrossWPM, stats.NetWPM, stats.Accuracy, stats.Errors, stats.Consistency)
+	if snapshot.Done {
+		result := fmt.Sprintf("Done in %.1fs!", stats.Duration.Seconds())
+		if pass := m.lessons[m.lesson].Pass; m.lessonActive && m.mode.Kind == modePrompt && pass != (PassCriteria{}) {
+			if pass.Passed(stats.NetWPM, stats.Accuracy) {
+				result += " Passed"
+			} else {
+				result += fmt.Sprintf(" Not passed (needs %.0f WPM net, %.0f%% accuracy)", pass.WPM, pass.Accuracy)
+			}
 		}
+		progress = resultsStyle.Render(result)
+		statsLine = resultsStyle.Render(statsLine)
+	}
+
+	// The plain copy of the target only helps when it's all there, not with
+	// a window into a streamed test
+	lines := []string{display, "", progress, statsLine, instructions}
+	if typeLine := "Type: " + target; uniseg.StringWidth(typeLine) <= width {
+		lines = append([]string{typeLine, ""}, lines...)
 	}
-	return maxWidth
+
+	// Lines that would still be too wide are cut rather than wrapped by
+	// whatever centers the screen
+	fit := lipgloss.NewStyle().MaxWidth(width)
+	return promptStyle.Render(fit.Render(strings.Join(lines, "\n")))
 }
 
-// Determine whether given label is a "special" key
-func isSpecialKey(label string) bool {
-	// TODO: why is this checking specific keys loaded from JSON which likely won't exist?
-	specialKeys := []string{
-		"Tab", "Caps Lock", "Shift", "Enter", "Backspace", "Space",
-		"CMD", "Alt", "FN", "win", "menü", "spaaaaaaaaaaaaaaaaaaaaaaaaaace",
+// Join all of a key's legends into a single string
+func keyLegend(key Key) string {
+	var parts []string
+	for _, label := range key.Labels {
+		if label != "" {
+			parts = append(parts, label)
+		}
 	}
+	return strings.Join(parts, "")
+}
 
-	for _, special := range specialKeys {
-		if strings.EqualFold(label, special) || strings.Contains(strings.ToLower(label), strings.ToLower(special)) {
-			return true
+// The legend that best represents a key when there's only room for one:
+// the unshifted (bottom) legend of a two-legend key like "!\n1", otherwise
+// the first one found
+func primaryLegend(key Key) string {
+	if len(key.Labels) > 6 && key.Labels[0] != "" && key.Labels[6] != "" {
+		return key.Labels[6]
+	}
+	for _, label := range key.Labels {
+		if label != "" {
+			return label
 		}
 	}
-	return false
+	return ""
+}
+
+// Find the total u-width of the keyboard
+func getKeyboardWidth(kb Keyboard) float64 {
+	minX, _, maxX, _ := kb.Bounds()
+	return maxX - minX
+}
+
+// Find the total u-height of the keyboard
+func getKeyboardHeight(kb Keyboard) float64 {
+	_, minY, _, maxY := kb.Bounds()
+	return maxY - minY
 }
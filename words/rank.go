@@ -0,0 +1,125 @@
+//go:build ignore
+
+// Rank the words of english-10k.txt by how often they're used
+//
+//	go run words/rank.go -wiki enwik7 -text Mark.Twain-Tom.Sawyer.txt -spoken English.json < words/english-10k.txt
+//
+// Three sources, so no one kind of English decides the order:
+//   - Written: the start of English Wikipedia, enwik7 from the test data of
+//     github.com/ulikunitz/xz (the first 10 MB of Matt Mahoney's enwik9)
+//   - Narrative: Tom Sawyer by Mark Twain, from the test data of
+//     github.com/klauspost/compress (Project Gutenberg)
+//   - Spoken: Wiktionary's frequency list of TV and film scripts, as
+//     data/data/English.json of github.com/ccojocar/zxcvbn-go
+//
+// A word's frequency is the mean of its share of the words in each source.
+// The spoken list only has ranks, so its frequencies follow Zipf's law,
+// and zxcvbn drops words that are also common passwords or names (like
+// "love" or "mark"), so it only counts for the words it has. Words none of
+// the sources use keep their order at the end.
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"html"
+	"log"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+var (
+	wordPattern = regexp.MustCompile(`[a-z]+`)
+	markup      = []*regexp.Regexp{
+		regexp.MustCompile(`<[^>]+>`),
+		regexp.MustCompile(`\[\[(Category|Image|File|[a-z][a-z\-]*):[^\]]*\]\]`),
+		regexp.MustCompile(`\{\{[^{}]*\}\}`),
+		regexp.MustCompile(`https?://\S+`),
+	}
+)
+
+// How often each word is used, as a share of all the words in text
+func frequencies(text string) map[string]float64 {
+	words := wordPattern.FindAllString(strings.ToLower(text), -1)
+	freqs := make(map[string]float64)
+	for _, word := range words {
+		freqs[word] += 1 / float64(len(words))
+	}
+	return freqs
+}
+
+// Text without the XML and wiki markup around it
+func stripWiki(text string) string {
+	text = markup[0].ReplaceAllString(text, " ")
+	text = html.UnescapeString(text)
+	for _, re := range markup {
+		text = re.ReplaceAllString(text, " ")
+	}
+	return text
+}
+
+func main() {
+	wikiFile := flag.String("wiki", "", "Wikipedia XML dump `file`")
+	textFile := flag.String("text", "", "plain text `file`")
+	spokenFile := flag.String("spoken", "", "zxcvbn English.json `file`")
+	flag.Parse()
+
+	read := func(name string) string {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return string(data)
+	}
+	written := frequencies(stripWiki(read(*wikiFile)))
+	narrative := frequencies(read(*textFile))
+
+	var spokenList struct{ List []string }
+	if err := json.Unmarshal([]byte(read(*spokenFile)), &spokenList); err != nil {
+		log.Fatal(err)
+	}
+	var harmonic float64
+	for rank := range spokenList.List {
+		harmonic += 1 / float64(rank+1)
+	}
+	spoken := make(map[string]float64)
+	for rank, word := range spokenList.List {
+		spoken[word] = 1 / (float64(rank+1) * harmonic)
+	}
+
+	var words []string
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		words = append(words, strings.Fields(scanner.Text())...)
+	}
+
+	score := make(map[string]float64, len(words))
+	for _, word := range words {
+		freqs := []float64{written[word], narrative[word]}
+		if f, ok := spoken[word]; ok {
+			freqs = append(freqs, f)
+		}
+		for _, f := range freqs {
+			score[word] += f / float64(len(freqs))
+		}
+	}
+	slices.SortStableFunc(words, func(a, b string) int {
+		switch {
+		case score[a] > score[b]:
+			return -1
+		case score[a] < score[b]:
+			return 1
+		}
+		return 0
+	})
+
+	w := bufio.NewWriter(os.Stdout)
+	defer w.Flush()
+	for _, word := range words {
+		fmt.Fprintln(w, word)
+	}
+}